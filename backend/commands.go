@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bpt-knowledge-center/backend/models"
+	"bpt-knowledge-center/backend/repositories"
+	"bpt-knowledge-center/backend/services"
+	"flag"
+	"log"
+)
+
+// runCommand executes a one-off maintenance command instead of starting the server,
+// e.g. `go run . migrate-chunks -dry-run`
+func runCommand(name string, args []string) {
+	switch name {
+	case "migrate-chunks":
+		migrateChunks(args)
+	case "reconcile":
+		reconcile(args)
+	case "reindex":
+		reindex(args)
+	default:
+		log.Fatalf("Unknown command %q (available: migrate-chunks, reconcile, reindex)", name)
+	}
+}
+
+// migrateChunks converts documents with nested chunks to the chunk-per-document layout
+func migrateChunks(args []string) {
+	fs := flag.NewFlagSet("migrate-chunks", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "report what would be migrated without writing")
+	createIndex := fs.Bool("create-index", false, "create or update the chunk search index")
+	fs.Parse(args)
+
+	if *createIndex && !*dryRun {
+		dims := services.LoadEmbeddingConfig().Dimension
+		if err := repositories.EnsureChunkSearchIndex(dims); err != nil {
+			log.Fatalf("Failed to create search index: %v", err)
+		}
+		log.Printf("Search index %s is up to date", repositories.GetSearchIndexName())
+	}
+
+	report, err := services.MigrateChunkLayout(*dryRun)
+	if err != nil {
+		log.Fatalf("Migration failed: %v", err)
+	}
+
+	log.Printf("Migrated %d documents (%d chunks), %d failed", report.Documents, report.Chunks, report.Failed)
+}
+
+// reconcile reports, and optionally removes, storage entries that no longer match the database
+func reconcile(args []string) {
+	fs := flag.NewFlagSet("reconcile", flag.ExitOnError)
+	repair := fs.Bool("repair", false, "delete orphaned objects and temp files")
+	prune := fs.Bool("prune-documents", false, "with -repair, also delete documents whose file is missing")
+	fs.Parse(args)
+
+	services.InitStorage()
+
+	report, err := services.Reconcile(services.ReconcileOptions{Repair: *repair, PruneDocuments: *prune})
+	if err != nil {
+		log.Fatalf("Reconcile failed: %v", err)
+	}
+
+	for _, key := range report.OrphanedObjects {
+		log.Printf("Orphaned object: %s", key)
+	}
+	for _, path := range report.OrphanedTempFiles {
+		log.Printf("Orphaned temp file: %s", path)
+	}
+	for _, id := range report.MissingObjects {
+		log.Printf("Missing file for document: %s", id)
+	}
+	log.Printf("%d orphaned objects, %d orphaned temp files, %d documents missing files; %d repaired, %d failed",
+		len(report.OrphanedObjects), len(report.OrphanedTempFiles), len(report.MissingObjects), report.Repaired, report.Failed)
+}
+
+// reindex re-embeds every chunk with another embedding model, resuming an interrupted
+// run with the same target. The server's EMBEDDING_* settings describe the current model.
+func reindex(args []string) {
+	fs := flag.NewFlagSet("reindex", flag.ExitOnError)
+	model := fs.String("model", "", "target embedding model (default EMBEDDING_MODEL)")
+	dimension := fs.Int("dimension", 0, "vector size of the target model (default EMBEDDING_DIMENSION)")
+	embeddingURL := fs.String("embedding-url", "", "embedding endpoint serving the target model (default REINDEX_EMBEDDING_URL, then EMBEDDING_URL)")
+	legacyModel := fs.String("legacy-model", "", "model of chunks saved before models were recorded (default EMBEDDING_MODEL)")
+	shadow := fs.Bool("shadow", false, "build into a shadow index while the current index keeps serving")
+	cutover := fs.Bool("cutover", false, "switch searches to a completed shadow build")
+	batchSize := fs.Int("batch-size", 0, "chunks per embedding request (default REINDEX_BATCH_SIZE)")
+	batchDelay := fs.Duration("batch-delay", 0, "pause between batches (default REINDEX_BATCH_DELAY)")
+	fs.Parse(args)
+
+	opts := services.ReindexOptions{
+		Model:        *model,
+		Dimension:    *dimension,
+		EmbeddingURL: *embeddingURL,
+		LegacyModel:  *legacyModel,
+		Shadow:       *shadow,
+		BatchSize:    *batchSize,
+		BatchDelay:   *batchDelay,
+	}
+
+	var run *models.ReindexRun
+	var err error
+	if *cutover {
+		run, err = services.CutoverReindex(opts)
+	} else {
+		run, err = services.RunReindex(opts)
+	}
+	if err != nil {
+		if run != nil {
+			log.Printf("Stopped after %s (%d/%d re-embedded, %d failed); run again to resume", run.Cursor, run.Processed, run.Total, run.Failed)
+		}
+		log.Fatalf("Reindex failed: %v", err)
+	}
+
+	log.Printf("Reindex to %s %s: %d/%d re-embedded, %d failed", run.TargetModel, run.Status, run.Processed, run.Total, run.Failed)
+}
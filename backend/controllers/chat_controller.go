@@ -1,21 +1,44 @@
 package controllers
 
 import (
+	"bpt-knowledge-center/backend/models"
+	"bpt-knowledge-center/backend/repositories"
 	"bpt-knowledge-center/backend/services"
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
 type ChatRequest struct {
-	Message string `json:"message"`
+	Message        string `json:"message"`
+	ConversationID string `json:"conversation_id"`
+	// Debug adds the final prompt and its token accounting to the response
+	Debug bool `json:"debug"`
+	RetrievalOptions
 }
 
-// Source represents a document source with filename and page
-type Source struct {
-	Filename string `json:"filename"`
-	Page     int    `json:"page"`
+// RetrievalOptions are the optional search settings accepted by the chat and search endpoints
+type RetrievalOptions struct {
+	TopK     int                     `json:"top_k"`
+	MinScore float64                 `json:"min_score"` // 0-1, see services.SearchParams.MinScore
+	Filters  *services.SearchFilters `json:"filters"`
+	Weights  *services.SearchWeights `json:"weights"` // vector/text fusion weights
+	// Neighbors adds the chunks around each hit, within a budget of ContextTokens
+	Neighbors     int `json:"neighbors"`
+	ContextTokens int `json:"context_tokens"`
+}
+
+// chatTurn carries the state shared by the blocking and streaming chat handlers
+type chatTurn struct {
+	Conversation *models.Conversation
+	History      []models.ChatMessage
+	Query        string
+	Matches      []services.ChunkMatch
 }
 
 func HandleChat(c *gin.Context) {
@@ -25,71 +48,245 @@ func HandleChat(c *gin.Context) {
 		return
 	}
 
-	// 1. Get Vector from Python Service
-	// We ask the Python brain: "What does this question look like as numbers?"
-	vector, err := services.GetQueryVector(req.Message)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error":   "Failed to process question (Embedding Service offline?)",
-			"details": err.Error(),
-		})
-		return
-	}
-
-	// 2. Search Couchbase (Vector Search)
-	// We ask Couchbase: "Find the text chunks most similar to these numbers."
-	matches, err := services.SearchSimilarChunks(vector)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error":   "Failed to search knowledge base",
-			"details": err.Error(),
-		})
+	turn, ok := prepareChatTurn(c, req)
+	if !ok {
 		return
 	}
 
-	// 3. Extract unique sources from matches
-	sourceMap := make(map[string]Source)
-	for _, match := range matches {
-		if match.Source != "" {
-			key := match.Source
-			if match.Page > 0 {
-				key = match.Source + string(rune(match.Page))
-			}
-			sourceMap[key] = Source{
-				Filename: match.Source,
-				Page:     match.Page,
-			}
-		}
-	}
-
-	// Convert to slice
-	var sources []Source
-	for _, s := range sourceMap {
-		sources = append(sources, s)
-	}
+	// 3. Fit the matches into the prompt and number them as citable sources
+	prompt := services.BuildPrompt(turn.Matches, req.Message, turn.History)
+	matches := prompt.Matches
+	sources := services.BuildSources(matches)
 
 	// 4. Formulate Response (Search + Generation)
 	// Try to generate a natural answer using Gemini
-	answer, err := services.GenerateAnswer(matches, req.Message)
+	answer, err := services.GenerateAnswer(prompt)
 
 	var responseText string
 	if err == nil && answer != "" {
 		responseText = answer
 	} else {
 		// Fallback to raw chunks if LLM fails or no key provided
-		if len(matches) == 0 {
-			responseText = "I couldn't find any relevant information in your documents."
-		} else {
-			var texts []string
-			for _, m := range matches {
-				texts = append(texts, m.Text)
+		responseText = fallbackAnswer(matches)
+	}
+	responseText = services.ApplyCitations(responseText, sources)
+
+	saveChatTurn(turn, req.Message, responseText, sources)
+
+	response := gin.H{
+		"response":        responseText,
+		"sources":         services.PresignSources(sources),
+		"conversation_id": req.ConversationID,
+		"query":           turn.Query,
+	}
+	if req.Debug {
+		response["debug"] = prompt
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// HandleChatStream answers like HandleChat but streams the result as Server-Sent Events:
+// a "sources" event first, then "delta" events with answer fragments, and a final "done"
+// event carrying the full response with validated citations. If generation fails, a "fallback" event replaces the
+// partial answer with the raw-chunk answer before "done" is sent.
+func HandleChatStream(c *gin.Context) {
+	var req ChatRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	// Retrieval errors are still reported as plain JSON, before the stream starts
+	turn, ok := prepareChatTurn(c, req)
+	if !ok {
+		return
+	}
+	prompt := services.BuildPrompt(turn.Matches, req.Message, turn.History)
+	matches := prompt.Matches
+	sources := services.BuildSources(matches)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+
+	c.SSEvent("sources", gin.H{
+		"sources":         services.PresignSources(sources),
+		"conversation_id": req.ConversationID,
+		"query":           turn.Query,
+	})
+	c.Writer.Flush()
+
+	answer, err := services.GenerateAnswerStream(c.Request.Context(), prompt, func(delta string) error {
+		c.SSEvent("delta", gin.H{"text": delta})
+		c.Writer.Flush()
+		return c.Request.Context().Err()
+	})
+
+	// Client went away; nothing left to send
+	if c.Request.Context().Err() != nil {
+		return
+	}
+
+	usedFallback := false
+	if err != nil || answer == "" {
+		if err != nil {
+			log.Printf("Streaming generation failed: %v", err)
+		}
+		usedFallback = true
+		answer = fallbackAnswer(matches)
+		c.SSEvent("fallback", gin.H{"response": answer})
+		c.Writer.Flush()
+	}
+
+	// Deltas were sent as generated; the final response has invalid citations stripped
+	answer = services.ApplyCitations(answer, sources)
+	saveChatTurn(turn, req.Message, answer, sources)
+
+	done := gin.H{
+		"response": answer,
+		"sources":  services.PresignSources(sources),
+		"fallback": usedFallback,
+	}
+	if req.Debug {
+		done["debug"] = prompt
+	}
+	c.SSEvent("done", done)
+	c.Writer.Flush()
+}
+
+// prepareChatTurn loads the conversation (if any), rewrites follow-ups into a standalone
+// query and retrieves matching chunks. On failure it writes the error response and returns false.
+func prepareChatTurn(c *gin.Context, req ChatRequest) (*chatTurn, bool) {
+	turn := &chatTurn{Query: req.Message}
+
+	if req.ConversationID != "" {
+		conv, err := repositories.GetConversationByID(req.ConversationID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Conversation not found"})
+			return nil, false
+		}
+		turn.Conversation = conv
+		turn.History = conv.Messages
+
+		// Follow-ups like "what about section 3?" need the history to be searchable
+		if len(turn.History) > 0 {
+			query, err := services.RewriteQuery(turn.History, req.Message)
+			if err != nil {
+				log.Printf("Warning: Failed to rewrite follow-up question: %v", err)
 			}
-			responseText = "Here is what I found in your documents:\n\n" + strings.Join(texts, "\n\n---\n\n")
+			turn.Query = query
+		}
+	}
+
+	result, ok := retrieveMatches(c, turn.Query, req.RetrievalOptions)
+	if !ok {
+		return nil, false
+	}
+	turn.Matches = result.Matches
+
+	return turn, true
+}
+
+// saveChatTurn appends the question and answer to the conversation, if there is one
+func saveChatTurn(turn *chatTurn, message string, answer string, sources []models.Source) {
+	if turn.Conversation == nil {
+		return
+	}
+
+	now := time.Now()
+	err := repositories.AppendMessages(turn.Conversation.ID,
+		models.ChatMessage{Role: "user", Content: message, Query: turn.Query, CreatedAt: now},
+		models.ChatMessage{Role: "assistant", Content: answer, Sources: sources, CreatedAt: now},
+	)
+	if err != nil {
+		log.Printf("Warning: Failed to save conversation messages: %v", err)
+		return
+	}
+
+	// Name untitled conversations after their first question
+	if turn.Conversation.Title == "" && len(turn.Conversation.Messages) == 0 {
+		if err := repositories.UpdateConversationTitle(turn.Conversation.ID, conversationTitle(message)); err != nil {
+			log.Printf("Warning: Failed to set conversation title: %v", err)
 		}
 	}
+}
+
+// conversationTitle shortens a question into a conversation title
+func conversationTitle(message string) string {
+	title := strings.TrimSpace(message)
+	if runes := []rune(title); len(runes) > 60 {
+		title = string(runes[:60]) + "..."
+	}
+	return title
+}
+
+// retrieveMatches embeds the question and runs the hybrid search. On failure it writes
+// the error response and returns false.
+func retrieveMatches(c *gin.Context, message string, opts RetrievalOptions) (*services.SearchResult, bool) {
+	// 1. Get Vector from Python Service
+	// We ask the Python brain: "What does this question look like as numbers?"
+	vector, err := services.GetQueryVector(message)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidEmbeddingInput):
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "Question could not be processed",
+				"details": err.Error(),
+			})
+		case errors.Is(err, services.ErrEmbeddingDimension), errors.Is(err, services.ErrEmbeddingModel):
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error":   "Embedding model does not match the search index",
+				"details": err.Error(),
+			})
+		default:
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error":   "Failed to process question (Embedding Service offline?)",
+				"details": err.Error(),
+			})
+		}
+		return nil, false
+	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"response": responseText,
-		"sources":  sources,
+	// 2. Search Couchbase (Vector + Full-Text Search)
+	// We ask Couchbase: "Find the text chunks most similar to these numbers and words."
+	result, err := services.SearchChunks(services.SearchParams{
+		Text:          message,
+		Vector:        vector,
+		Weights:       opts.Weights,
+		TopK:          opts.TopK,
+		MinScore:      opts.MinScore,
+		Filters:       opts.Filters,
+		Neighbors:     opts.Neighbors,
+		ContextTokens: opts.ContextTokens,
 	})
+	if errors.Is(err, services.ErrEmbeddingModel) || errors.Is(err, services.ErrEmbeddingDimension) {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Embedding model does not match the search index",
+			"details": err.Error(),
+		})
+		return nil, false
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to search knowledge base",
+			"details": err.Error(),
+		})
+		return nil, false
+	}
+
+	return result, true
+}
+
+// fallbackAnswer returns the raw chunks when the LLM fails or no key is provided
+func fallbackAnswer(matches []services.ChunkMatch) string {
+	if len(matches) == 0 {
+		return "I couldn't find any relevant information in your documents."
+	}
+
+	var texts []string
+	for i, m := range matches {
+		texts = append(texts, fmt.Sprintf("[%d] %s", i+1, m.Text))
+	}
+	return "Here is what I found in your documents:\n\n" + strings.Join(texts, "\n\n---\n\n")
 }
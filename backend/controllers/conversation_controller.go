@@ -0,0 +1,75 @@
+package controllers
+
+import (
+	"bpt-knowledge-center/backend/models"
+	"bpt-knowledge-center/backend/repositories"
+	"bpt-knowledge-center/backend/services"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type CreateConversationRequest struct {
+	Title string `json:"title"`
+}
+
+func CreateConversation(c *gin.Context) {
+	var req CreateConversationRequest
+	// Body is optional; an empty title is filled in from the first question
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Input"})
+			return
+		}
+	}
+
+	conv := models.Conversation{Title: req.Title}
+	if err := repositories.CreateConversation(&conv); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create conversation"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, conv)
+}
+
+func GetConversations(c *gin.Context) {
+	conversations, err := repositories.GetAllConversations()
+	if err != nil {
+		log.Printf("Error fetching conversations: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch conversations"})
+		return
+	}
+
+	if conversations == nil {
+		conversations = []models.Conversation{}
+	}
+
+	c.JSON(http.StatusOK, conversations)
+}
+
+func GetConversation(c *gin.Context) {
+	conv, err := repositories.GetConversationByID(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Conversation not found"})
+		return
+	}
+
+	// Stored sources link to the download path; readers get fresh presigned URLs
+	for i, msg := range conv.Messages {
+		if len(msg.Sources) > 0 {
+			conv.Messages[i].Sources = services.PresignSources(msg.Sources)
+		}
+	}
+
+	c.JSON(http.StatusOK, conv)
+}
+
+func DeleteConversation(c *gin.Context) {
+	if err := repositories.DeleteConversation(c.Param("id")); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete conversation"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Conversation deleted"})
+}
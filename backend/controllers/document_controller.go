@@ -3,7 +3,12 @@ package controllers
 import (
 	"bpt-knowledge-center/backend/models"
 	"bpt-knowledge-center/backend/repositories"
+	"bpt-knowledge-center/backend/services"
+	"errors"
+	"io"
+	"log"
 	"net/http"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 )
@@ -62,7 +67,7 @@ func UpdateDocumentName(c *gin.Context) {
 
 func DeleteDocument(c *gin.Context) {
 	id := c.Param("id")
-	err := repositories.DeleteDocument(id)
+	err := services.DeleteDocument(id)
 
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete document"})
@@ -86,3 +91,90 @@ func GetDocuments(c *gin.Context) {
 
 	c.JSON(http.StatusOK, docs)
 }
+
+// DownloadDocument redirects to a short-lived presigned URL for the document's file.
+// ?version=N selects an older version, ?mode=url returns the URL as JSON instead and
+// ?mode=stream sends the file through the backend.
+func DownloadDocument(c *gin.Context) {
+	doc, err := repositories.GetDocumentByID(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+		return
+	}
+
+	version := doc.Version
+	if v := c.Query("version"); v != "" {
+		version, err = strconv.Atoi(v)
+		if err != nil || version < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid version"})
+			return
+		}
+	}
+
+	key, err := services.ResolveObjectKey(doc, version)
+	if errors.Is(err, services.ErrObjectNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File for this version not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to locate file"})
+		return
+	}
+
+	mode := c.Query("mode")
+	if mode == "stream" {
+		streamObject(c, key, doc.Filename)
+		return
+	}
+
+	url, expiresAt, err := services.PresignDownload(key, doc.Filename)
+	if errors.Is(err, services.ErrPresignUnsupported) {
+		// Local and in-memory stores have no direct URL, so the backend serves the file
+		if mode == "url" {
+			c.JSON(http.StatusOK, gin.H{
+				"url":     services.DownloadPath(doc.ID, version) + "&mode=stream",
+				"version": version,
+			})
+			return
+		}
+		streamObject(c, key, doc.Filename)
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create download link"})
+		return
+	}
+
+	if mode == "url" {
+		c.JSON(http.StatusOK, gin.H{
+			"url":        url,
+			"version":    version,
+			"expires_at": expiresAt,
+		})
+		return
+	}
+
+	c.Redirect(http.StatusFound, url)
+}
+
+// streamObject proxies a stored object to the client as an attachment
+func streamObject(c *gin.Context, key string, filename string) {
+	body, info, err := services.OpenObject(key)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found in storage"})
+		return
+	}
+	defer body.Close()
+
+	c.Header("Content-Disposition", services.ContentDisposition(filename))
+	contentType := info.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	c.Header("Content-Type", contentType)
+	if info.Size > 0 {
+		c.Header("Content-Length", strconv.FormatInt(info.Size, 10))
+	}
+	c.Status(http.StatusOK)
+	if _, err := io.Copy(c.Writer, body); err != nil {
+		log.Printf("Download of %s interrupted: %v", key, err)
+	}
+}
@@ -0,0 +1,38 @@
+package controllers
+
+import (
+	"bpt-knowledge-center/backend/repositories"
+	"bpt-knowledge-center/backend/services"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+func GetJob(c *gin.Context) {
+	job, err := repositories.GetJobByID(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
+
+// RetryJob re-queues a failed job from its last successful stage
+func RetryJob(c *gin.Context) {
+	job, err := services.RetryIngestion(c.Param("id"))
+	switch {
+	case errors.Is(err, services.ErrJobNotRetryable):
+		c.JSON(http.StatusConflict, gin.H{"error": "Only failed jobs can be retried", "status": job.Status})
+		return
+	case errors.Is(err, services.ErrQueueFull):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Ingestion queue is full, retry the job later"})
+		return
+	case err != nil:
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, job)
+}
@@ -0,0 +1,85 @@
+package controllers
+
+import (
+	"bpt-knowledge-center/backend/models"
+	"bpt-knowledge-center/backend/services"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Reconcile reports orphaned objects, orphaned temp files and documents whose file is
+// missing. With {"repair": true} the orphans are deleted.
+func Reconcile(c *gin.Context) {
+	var opts services.ReconcileOptions
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&opts); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Input"})
+			return
+		}
+	}
+
+	report, err := services.Reconcile(opts)
+	if err != nil {
+		log.Printf("Reconcile failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reconcile storage"})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// StartReindex re-embeds every chunk in the background, see services.RunReindex.
+// With {"cutover": true} it switches searches to a completed shadow build instead.
+func StartReindex(c *gin.Context) {
+	var req struct {
+		services.ReindexOptions
+		Cutover bool `json:"cutover"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Input"})
+			return
+		}
+	}
+
+	if services.ReindexActive() {
+		c.JSON(http.StatusConflict, gin.H{"error": "A reindex is already running"})
+		return
+	}
+	if req.Cutover {
+		run, err := services.GetReindexRun()
+		if err != nil || run == nil || !run.Shadow || run.Status != models.ReindexBuilt {
+			c.JSON(http.StatusConflict, gin.H{"error": "No completed shadow reindex to cut over to"})
+			return
+		}
+	}
+
+	go func() {
+		var err error
+		if req.Cutover {
+			_, err = services.CutoverReindex(req.ReindexOptions)
+		} else {
+			_, err = services.RunReindex(req.ReindexOptions)
+		}
+		if err != nil && !errors.Is(err, services.ErrReindexRunning) {
+			log.Printf("Reindex failed: %v", err)
+		}
+	}()
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "Reindex started"})
+}
+
+// GetReindexStatus reports the progress of the latest reindex
+func GetReindexStatus(c *gin.Context) {
+	run, err := services.GetReindexRun()
+	if err != nil {
+		log.Printf("Failed to load reindex run: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load reindex status"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"run": run, "active": services.ReindexActive()})
+}
@@ -0,0 +1,34 @@
+package controllers
+
+import (
+	"bpt-knowledge-center/backend/services"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type SearchRequest struct {
+	Query string `json:"query"`
+	RetrievalOptions
+}
+
+// HandleSearch runs retrieval only and returns the ranked chunks with their
+// per-method scores and reranking stats, for debugging rankings
+func HandleSearch(c *gin.Context) {
+	var req SearchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	result, ok := retrieveMatches(c, req.Query, req.RetrievalOptions)
+	if !ok {
+		return
+	}
+
+	if result.Matches == nil {
+		result.Matches = []services.ChunkMatch{}
+	}
+
+	c.JSON(http.StatusOK, result)
+}
@@ -4,117 +4,274 @@ import (
 	"bpt-knowledge-center/backend/models"
 	"bpt-knowledge-center/backend/repositories"
 	"bpt-knowledge-center/backend/services"
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
-	"time"
+	"path/filepath"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 )
 
+// UploadDocument stores the file and queues it for ingestion. Parsing, embedding and
+// saving happen in the background; progress is available from GET /api/jobs/:id.
 func UploadDocument(c *gin.Context) {
-	// 1. Get the file from the request
-	fileHeader, err := c.FormFile("file")
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "No file uploaded"})
+	// Cap the body so oversized uploads are cut off instead of buffered; the margin
+	// leaves room for the multipart framing and form fields
+	maxSize := services.MaxUploadSize()
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxSize+1<<20)
+
+	// 1. Read the form, streaming the file part into a temp file. Fields may come
+	// before or after the file, so they are collected on the way.
+	form, err := readUploadForm(c.Request)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		rejectUpload(c, &services.UploadError{Code: services.UploadTooLarge, Message: fmt.Sprintf("File exceeds the maximum size of %d bytes", maxSize)})
+		return
+	} else if err != nil {
+		log.Printf("Failed to read upload: %v", err)
+		uploadFailed(c, http.StatusBadRequest, "invalid_form", "Unable to read the upload form")
+		return
+	} else if form.upload == nil {
+		uploadFailed(c, http.StatusBadRequest, "no_file", "No file uploaded")
 		return
 	}
+	upload, filename := form.upload, form.filename
+	// The temp file is handed to the ingestion job once that is saved
+	keepTemp := false
+	defer func() {
+		if !keepTemp {
+			upload.Remove()
+		}
+	}()
 
 	// Get optional display name
-	displayName := c.PostForm("display_name")
+	displayName := form.fields["display_name"]
 	if displayName == "" {
-		displayName = fileHeader.Filename
+		displayName = filename
 	}
 
 	// Check if this is a re-upload (update existing document)
-	documentID := c.PostForm("document_id")
-	var existingVersion int = 0
-
-	// If re-uploading, get existing version and DELETE old document first
-	// This removes old chunks to prevent AI conflicts
+	// The old version stays in place until the new one is saved under the same ID
+	documentID := form.fields["document_id"]
+	var existingDoc *models.Document
 	if documentID != "" {
-		existingDoc, err := repositories.GetDocumentByID(documentID)
-		if err == nil && existingDoc != nil {
-			existingVersion = existingDoc.Version
-			// Delete old document to remove old chunks from vector index
-			if err := repositories.DeleteDocument(documentID); err != nil {
-				log.Printf("Warning: Failed to delete old document: %v", err)
-			}
+		if doc, err := repositories.GetDocumentByID(documentID); err == nil {
+			existingDoc = doc
 		}
 	}
+	isReupload := existingDoc != nil
+	if !isReupload {
+		documentID = "doc::" + uuid.New().String()
+	}
 
-	file, err := fileHeader.Open()
+	// Nothing is stored before the file passes validation and the scanner
+	contentType, err := services.ValidateUpload(upload, filename)
+	if err == nil {
+		err = services.ScanUpload(c.Request.Context(), upload)
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to open file"})
+		rejectUpload(c, err)
 		return
 	}
-	defer file.Close()
 
-	fileURL, err := services.UploadFile(file, fileHeader.Filename, fileHeader.Size)
+	// Identical content is stored once; the response points to the existing document
+	existingID, err := services.FindDuplicate(upload.Hash)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Storage upload failed: " + err.Error()})
+		log.Printf("Duplicate check for %s failed: %v", filename, err)
+	} else if existingID != "" {
+		respondDuplicate(c, existingID, documentID, isReupload, filename)
 		return
 	}
 
-	tempPath := "./temp/" + fileHeader.Filename
-	if err := c.SaveUploadedFile(fileHeader, tempPath); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save temp file"})
-		return
+	// The version is only taken once the upload is accepted, so rejected files
+	// leave no gaps in the history
+	version := 1
+	if isReupload {
+		if version, err = services.NextVersion(existingDoc); err != nil {
+			log.Printf("Failed to allocate a version of %s: %v", documentID, err)
+			uploadFailed(c, http.StatusInternalServerError, "version_failed", "Failed to allocate a document version")
+			return
+		}
 	}
 
-	parsedData, err := services.SendToParser(tempPath)
+	// Content hash and real MIME type go into the object key and metadata
+	objectKey := services.ObjectKey(documentID, version, upload.Hash, filename)
+
+	spooled, err := upload.Open()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Parsing failed: " + err.Error()})
+		uploadFailed(c, http.StatusInternalServerError, "read_failed", "Unable to read file")
 		return
 	}
+	err = services.UploadFile(spooled, objectKey, upload.Size, contentType)
+	spooled.Close()
+	if err != nil {
+		uploadFailed(c, http.StatusInternalServerError, "storage_failed", "Storage upload failed: "+err.Error())
+		return
+	}
+
+	jobID := "job::" + uuid.New().String()
+	job := models.IngestionJob{
+		ID:          jobID,
+		Status:      models.JobQueued,
+		DocumentID:  documentID,
+		Filename:    filename,
+		DisplayName: displayName,
+		FileURL:     services.DownloadPath(documentID, version),
+		ObjectKey:   objectKey,
+		FileHash:    upload.Hash,
+		FileSize:    upload.Size,
+		ContentType: contentType,
+		Version:     version,
+		IsReupload:  isReupload,
+		UploadedBy:  form.fields["uploaded_by"],
+		ChangeNote:  form.fields["change_note"],
+		TempPath:    upload.Path,
+		ParsedPath:  filepath.Join(services.TempDir(), uuid.New().String()+".parsed.json"),
+	}
+	if err := repositories.SaveJob(&job); err != nil {
+		// Nothing references the upload without a job
+		services.DeleteObject(objectKey)
+		uploadFailed(c, http.StatusInternalServerError, "job_failed", "Failed to create ingestion job")
+		return
+	}
+	keepTemp = true
+
+	if err := services.EnqueueIngestion(&job); err != nil {
+		log.Printf("Failed to queue job %s: %v", job.ID, err)
+		job.Status = models.JobFailed
+		job.Error = err.Error()
+		repositories.SaveJob(&job)
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error":  "Ingestion queue is full, retry the job later",
+			"code":   "queue_full",
+			"job_id": job.ID,
+		})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message": "Upload accepted",
+		"job_id":  job.ID,
+		"status":  job.Status,
+		"id":      job.DocumentID,
+		"url":     job.FileURL,
+		"version": job.Version,
+	})
+}
+
+// maxFieldSize bounds the value of a text field in the upload form
+const maxFieldSize = 64 << 10
+
+// uploadForm is a parsed upload request
+type uploadForm struct {
+	upload   *services.TempUpload
+	filename string
+	fields   map[string]string
+}
+
+// readUploadForm walks the multipart body part by part. The "file" part is copied
+// straight into SpoolUpload, which hashes it and keeps the header for sniffing in the
+// same pass, so the file is never buffered by the form parser. The upload is nil if
+// the request has no file part; on error nothing is left on disk.
+func readUploadForm(req *http.Request) (*uploadForm, error) {
+	reader, err := req.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
 
-	// Create new document (with same ID if re-upload, new ID otherwise)
-	var docID string
-	var version int = 1
-
-	if documentID != "" && existingVersion > 0 {
-		docID = documentID
-		version = existingVersion + 1
-	} else {
-		docID = "doc::" + uuid.New().String()
-	}
-
-	doc := models.Document{
-		ID:           docID,
-		Type:         "document",
-		Filename:     fileHeader.Filename,
-		DisplayName:  displayName,
-		FileURL:      fileURL,
-		ContentType:  parsedData.ContentType,
-		UploadedAt:   time.Now(),
-		UpdatedAt:    time.Now(),
-		ElementCount: parsedData.ElementCount,
-		Version:      version,
-		DocType:      "knowledge-base.bpt-docs",
-		Chunks:       make([]models.DocumentChunk, len(parsedData.Data)),
-	}
-
-	for i, item := range parsedData.Data {
-		doc.Chunks[i] = models.DocumentChunk{
-			ChunkID:  item.ElementID,
-			Text:     item.Text,
-			Type:     item.Type,
-			Metadata: item.Metadata,
-			Vector:   item.Vector,
+	form := &uploadForm{fields: map[string]string{}}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return form, nil
+		}
+		if err != nil {
+			form.discard()
+			return nil, err
 		}
+
+		switch {
+		case part.FormName() == "file" && part.FileName() != "" && form.upload == nil:
+			form.filename = part.FileName()
+			form.upload, err = services.SpoolUpload(part, form.filename)
+		case part.FileName() == "":
+			var value []byte
+			value, err = io.ReadAll(io.LimitReader(part, maxFieldSize))
+			form.fields[part.FormName()] = string(value)
+		}
+		part.Close()
+		if err != nil {
+			form.discard()
+			return nil, err
+		}
+	}
+}
+
+// discard removes the spooled file of a form that is not used
+func (f *uploadForm) discard() {
+	if f.upload != nil {
+		f.upload.Remove()
 	}
+}
 
-	// Save new version to Couchbase
-	if err := repositories.SaveDocument(&doc); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database save failed"})
+// respondDuplicate answers an upload whose content is already stored. Re-uploading a
+// document's current file changes nothing; other duplicates follow DUPLICATE_POLICY.
+func respondDuplicate(c *gin.Context, existingID string, documentID string, isReupload bool, filename string) {
+	if isReupload && existingID == documentID {
+		c.JSON(http.StatusOK, gin.H{
+			"message":   "File is unchanged",
+			"id":        existingID,
+			"duplicate": true,
+		})
 		return
 	}
 
+	if isReupload || services.DuplicatePolicy() == services.DuplicateReject {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":       "An identical file has already been uploaded",
+			"code":        "duplicate_file",
+			"existing_id": existingID,
+		})
+		return
+	}
+
+	if err := services.LinkDuplicate(existingID, filename); err != nil {
+		log.Printf("Failed to link %s to %s: %v", filename, existingID, err)
+	}
 	c.JSON(http.StatusOK, gin.H{
-		"message": "Ingestion successful",
-		"id":      doc.ID,
-		"url":     doc.FileURL,
-		"count":   doc.ElementCount,
-		"version": doc.Version,
+		"message":     "Identical file already uploaded, linked to the existing document",
+		"id":          existingID,
+		"existing_id": existingID,
+		"duplicate":   true,
 	})
 }
+
+// uploadErrorStatus maps validation error codes to HTTP statuses
+var uploadErrorStatus = map[string]int{
+	services.UploadTooLarge:        http.StatusRequestEntityTooLarge,
+	services.UploadEmpty:           http.StatusBadRequest,
+	services.UploadUnsupportedType: http.StatusUnsupportedMediaType,
+	services.UploadTypeMismatch:    http.StatusUnsupportedMediaType,
+	services.UploadEncrypted:       http.StatusUnprocessableEntity,
+	services.UploadInfected:        http.StatusUnprocessableEntity,
+	services.UploadScanFailed:      http.StatusServiceUnavailable,
+}
+
+// rejectUpload responds to a validation or scanning failure
+func rejectUpload(c *gin.Context, err error) {
+	var uploadErr *services.UploadError
+	if !errors.As(err, &uploadErr) {
+		log.Printf("Upload validation failed: %v", err)
+		uploadFailed(c, http.StatusInternalServerError, "read_failed", "Unable to read file")
+		return
+	}
+	uploadFailed(c, uploadErrorStatus[uploadErr.Code], uploadErr.Code, uploadErr.Message)
+}
+
+// uploadFailed writes an upload error with its machine-readable code
+func uploadFailed(c *gin.Context, status int, code string, message string) {
+	c.JSON(status, gin.H{"error": message, "code": code})
+}
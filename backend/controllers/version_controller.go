@@ -0,0 +1,110 @@
+package controllers
+
+import (
+	"bpt-knowledge-center/backend/services"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/couchbase/gocb/v2"
+	"github.com/gin-gonic/gin"
+)
+
+type RestoreVersionRequest struct {
+	RestoredBy string `json:"restored_by"`
+	ChangeNote string `json:"change_note"`
+}
+
+// GetDocumentVersions lists the version history of a document, newest first
+func GetDocumentVersions(c *gin.Context) {
+	versions, err := services.ListVersions(c.Param("id"))
+	if errors.Is(err, gocb.ErrDocumentNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+		return
+	} else if err != nil {
+		log.Printf("Failed to list versions of %s: %v", c.Param("id"), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list versions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, versions)
+}
+
+// RestoreDocumentVersion makes an older version current again without re-parsing it
+func RestoreDocumentVersion(c *gin.Context) {
+	version, err := strconv.Atoi(c.Param("v"))
+	if err != nil || version < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid version"})
+		return
+	}
+
+	var req RestoreVersionRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Input"})
+			return
+		}
+	}
+
+	restored, err := services.RestoreVersion(c.Param("id"), version, req.RestoredBy, req.ChangeNote)
+	switch {
+	case errors.Is(err, services.ErrVersionNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Version not found"})
+		return
+	case errors.Is(err, services.ErrVersionIsCurrent):
+		c.JSON(http.StatusConflict, gin.H{"error": "Version is already current"})
+		return
+	case errors.Is(err, services.ErrVersionNotRestorable):
+		c.JSON(http.StatusConflict, gin.H{"error": "Version can no longer be restored"})
+		return
+	case errors.Is(err, gocb.ErrDocumentNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+		return
+	case err != nil:
+		log.Printf("Failed to restore %s v%d: %v", c.Param("id"), version, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore version"})
+		return
+	}
+
+	c.JSON(http.StatusOK, restored)
+}
+
+// DiffDocumentVersions reports the sections added, removed and modified between two
+// versions. With summary=true an LLM-written summary of the changes is included.
+func DiffDocumentVersions(c *gin.Context) {
+	from, errFrom := strconv.Atoi(c.Query("from"))
+	to, errTo := strconv.Atoi(c.Query("to"))
+	if errFrom != nil || errTo != nil || from < 1 || to < 1 || from == to {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from and to must be two different versions"})
+		return
+	}
+
+	diff, err := services.DiffVersions(c.Param("id"), from, to)
+	switch {
+	case errors.Is(err, services.ErrVersionNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Version not found"})
+		return
+	case errors.Is(err, services.ErrVersionNotRestorable):
+		c.JSON(http.StatusConflict, gin.H{"error": "Version content is no longer stored"})
+		return
+	case errors.Is(err, gocb.ErrDocumentNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+		return
+	case err != nil:
+		log.Printf("Failed to diff %s v%d..v%d: %v", c.Param("id"), from, to, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compare versions"})
+		return
+	}
+
+	if c.Query("summary") == "true" {
+		summary, err := services.SummarizeChanges(c.Request.Context(), diff)
+		if err != nil {
+			// The diff is still useful without its summary
+			log.Printf("Failed to summarize changes of %s: %v", diff.DocumentID, err)
+		}
+		diff.Summary = summary
+	}
+
+	c.JSON(http.StatusOK, diff)
+}
@@ -18,7 +18,19 @@ func main() {
 
 	// 2. Initialize Services
 	config.ConnectDB()
+
+	// Maintenance commands run instead of the server
+	if len(os.Args) > 1 {
+		runCommand(os.Args[1], os.Args[2:])
+		return
+	}
+
 	services.InitStorage()
+	services.InitLLM()
+	services.InitEmbedder()
+	services.InitReranker()
+	services.InitScanner()
+	services.StartIngestionWorkers()
 
 	// 3. Setup Router
 	r := routes.SetupRouter()
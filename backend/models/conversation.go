@@ -0,0 +1,36 @@
+package models
+
+import "time"
+
+// Conversation is a persisted multi-turn chat session
+type Conversation struct {
+	ID        string        `json:"id"`
+	Type      string        `json:"type"`
+	Title     string        `json:"title"`
+	Messages  []ChatMessage `json:"messages"`
+	CreatedAt time.Time     `json:"created_at"`
+	UpdatedAt time.Time     `json:"updated_at"`
+}
+
+// ChatMessage is a single turn in a conversation
+type ChatMessage struct {
+	Role      string    `json:"role"` // "user" or "assistant"
+	Content   string    `json:"content"`
+	Query     string    `json:"query,omitempty"` // standalone query used for retrieval
+	Sources   []Source  `json:"sources,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Source is a retrieved chunk that an answer can cite inline as [Index]
+type Source struct {
+	Index       int    `json:"index"`
+	DocumentID  string `json:"document_id"`
+	DisplayName string `json:"display_name"`
+	Filename    string `json:"filename"`
+	Version     int    `json:"version"`
+	Page        int    `json:"page"`
+	ChunkID     string `json:"chunk_id"`
+	Snippet     string `json:"snippet"`
+	FileURL     string `json:"file_url"`
+	Cited       bool   `json:"cited"` // whether the answer references this source
+}
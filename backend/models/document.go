@@ -1,28 +1,114 @@
 package models
 
-import "time"
+import (
+	"fmt"
+	"time"
+)
 
 type Document struct {
-	ID           string          `json:"id"`
-	Type         string          `json:"type"`
-	Filename     string          `json:"filename"`
-	DisplayName  string          `json:"display_name"`
-	FileURL      string          `json:"file_url"`
-	ContentType  string          `json:"content_type"`
-	UploadedAt   time.Time       `json:"uploaded_at"`
-	UpdatedAt    time.Time       `json:"updated_at"`
-	ElementCount int             `json:"element_count"`
-	Version      int             `json:"version"`
-	DocType      string          `json:"_type"`
-	Category     string          `json:"category"`
-	Description  string          `json:"description"`
-	Chunks       []DocumentChunk `json:"chunks"`
+	ID           string    `json:"id"`
+	Type         string    `json:"type"`
+	Filename     string    `json:"filename"`
+	DisplayName  string    `json:"display_name"`
+	FileURL      string    `json:"file_url"`
+	ObjectKey    string    `json:"object_key"`
+	FileHash     string    `json:"file_hash"` // hex SHA-256 of the uploaded file
+	FileSize     int64     `json:"file_size"`
+	ContentType  string    `json:"content_type"`
+	UploadedAt   time.Time `json:"uploaded_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+	ElementCount int       `json:"element_count"`
+	Version      int       `json:"version"`
+	DocType      string    `json:"_type"`
+	Category     string    `json:"category"`
+	Description  string    `json:"description"`
+	// Aliases are filenames of identical uploads linked to this document instead of stored
+	Aliases []string `json:"aliases,omitempty"`
+	// Chunks is only populated in memory during ingestion and on legacy documents
+	// that predate the chunk-per-document layout; chunks are stored as Chunk documents.
+	Chunks []DocumentChunk `json:"chunks,omitempty"`
 }
 
 type DocumentChunk struct {
-	ChunkID  string                 `json:"chunk_id"`
-	Text     string                 `json:"text"`
-	Type     string                 `json:"type"`
-	Metadata map[string]interface{} `json:"metadata"`
-	Vector   []float32              `json:"vector"`
+	ChunkID     string                 `json:"chunk_id"`
+	Text        string                 `json:"text"`
+	Type        string                 `json:"type"`
+	Metadata    map[string]interface{} `json:"metadata"`
+	Vector      []float32              `json:"vector"`
+	Fingerprint string                 `json:"fingerprint,omitempty"` // hash of the normalized text
+	// EmbeddingModel produced Vector
+	EmbeddingModel string `json:"embedding_model,omitempty"`
+}
+
+// Chunk is a searchable passage stored as its own document under
+// chunk::<docID>::v<version>::<n>. Only chunks of the document's current version are
+// active; chunks of older versions are kept but excluded from search.
+type Chunk struct {
+	ID          string                 `json:"id"`
+	Type        string                 `json:"type"`
+	DocumentID  string                 `json:"document_id"`
+	Version     int                    `json:"version"`
+	Active      bool                   `json:"active"`
+	Seq         int                    `json:"seq"`
+	ChunkID     string                 `json:"chunk_id"`
+	ElementType string                 `json:"element_type"`
+	Text        string                 `json:"text"`
+	Page        int                    `json:"page"`
+	Source      string                 `json:"source"`
+	Metadata    map[string]interface{} `json:"metadata"`
+	Vector      []float32              `json:"vector"`
+	Fingerprint string                 `json:"fingerprint,omitempty"`
+	// Model and size of Vector. Chunks saved before these were recorded are
+	// backfilled by the reindex command.
+	EmbeddingModel     string `json:"embedding_model,omitempty"`
+	EmbeddingDimension int    `json:"embedding_dimension,omitempty"`
+	// Vector of the model being reindexed into the shadow index, see ShadowSlot
+	ShadowVector    []float32 `json:"shadow_vector,omitempty"`
+	ShadowModel     string    `json:"shadow_model,omitempty"`
+	ShadowDimension int       `json:"shadow_dimension,omitempty"`
+	// Copied from the document so searches can pre-filter on them
+	Category    string    `json:"category"`
+	ContentType string    `json:"content_type"`
+	UploadedAt  time.Time `json:"uploaded_at"`
+}
+
+// ChunkKey returns the document key of the n-th chunk of a document version
+func ChunkKey(documentID string, version int, n int) string {
+	return fmt.Sprintf("chunk::%s::v%d::%d", documentID, version, n)
+}
+
+// NewChunk converts the n-th parsed chunk of a document into its stored form. New
+// chunks are inactive until their version is activated.
+func NewChunk(doc *Document, n int, dc DocumentChunk) Chunk {
+	chunk := Chunk{
+		ID:          ChunkKey(doc.ID, doc.Version, n),
+		Type:        "chunk",
+		DocumentID:  doc.ID,
+		Version:     doc.Version,
+		Seq:         n,
+		ChunkID:     dc.ChunkID,
+		ElementType: dc.Type,
+		Text:        dc.Text,
+		Metadata:    dc.Metadata,
+		Vector:      dc.Vector,
+		Fingerprint: dc.Fingerprint,
+		Category:    doc.Category,
+		ContentType: doc.ContentType,
+		UploadedAt:  doc.UploadedAt,
+	}
+
+	if page, ok := dc.Metadata["page"].(float64); ok {
+		chunk.Page = int(page)
+	} else if page, ok := dc.Metadata["page"].(int); ok {
+		chunk.Page = page
+	}
+	if source, ok := dc.Metadata["source"].(string); ok {
+		chunk.Source = source
+	}
+	if len(dc.Vector) > 0 {
+		chunk.EmbeddingModel = dc.EmbeddingModel
+		chunk.EmbeddingDimension = len(dc.Vector)
+	}
+
+	return chunk
 }
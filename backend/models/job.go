@@ -0,0 +1,41 @@
+package models
+
+import "time"
+
+// Ingestion job states, in pipeline order
+const (
+	JobQueued    = "queued"
+	JobParsing   = "parsing"
+	JobEmbedding = "embedding"
+	JobSaved     = "saved"
+	JobFailed    = "failed"
+)
+
+// IngestionJob tracks an uploaded file through parsing, embedding and saving
+type IngestionJob struct {
+	ID             string    `json:"id"`
+	Type           string    `json:"type"`
+	Status         string    `json:"status"`
+	CompletedStage string    `json:"completed_stage"` // last stage that finished successfully
+	DocumentID     string    `json:"document_id"`
+	Filename       string    `json:"filename"`
+	DisplayName    string    `json:"display_name"`
+	FileURL        string    `json:"file_url"`
+	ObjectKey      string    `json:"object_key"`
+	FileHash       string    `json:"file_hash"`
+	FileSize       int64     `json:"file_size"`
+	ContentType    string    `json:"content_type"`
+	IsReupload     bool      `json:"is_reupload"`
+	UploadedBy     string    `json:"uploaded_by,omitempty"`
+	ChangeNote     string    `json:"change_note,omitempty"`
+	TempPath       string    `json:"temp_path"`
+	ParsedPath     string    `json:"parsed_path"` // checkpoint of the parser output
+	Attempts       int       `json:"attempts"`
+	Error          string    `json:"error,omitempty"`
+	ElementCount   int       `json:"element_count"`
+	ChunksReused   int       `json:"chunks_reused"`   // vectors taken from the previous version
+	ChunksEmbedded int       `json:"chunks_embedded"` // vectors computed for this upload
+	Version        int       `json:"version"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
@@ -0,0 +1,69 @@
+package models
+
+import "time"
+
+// VectorSlot names the chunk fields holding one embedding. Chunks have a primary slot
+// and a shadow slot that a reindex fills while the primary keeps serving searches.
+type VectorSlot struct {
+	Name           string
+	VectorField    string
+	ModelField     string
+	DimensionField string
+}
+
+var (
+	PrimarySlot = VectorSlot{"primary", "vector", "embedding_model", "embedding_dimension"}
+	ShadowSlot  = VectorSlot{"shadow", "shadow_vector", "shadow_model", "shadow_dimension"}
+)
+
+// VectorSlotNamed returns the slot with the given name, defaulting to the primary one
+func VectorSlotNamed(name string) VectorSlot {
+	if name == ShadowSlot.Name {
+		return ShadowSlot
+	}
+	return PrimarySlot
+}
+
+// SearchRoute tells which index and vector slot serve queries embedded with a model
+type SearchRoute struct {
+	Model     string `json:"model"`
+	Dimension int    `json:"dimension"`
+	Index     string `json:"index"`
+	Slot      string `json:"slot"`
+}
+
+// SearchRouting is the stored set of routes, one per embedding model
+type SearchRouting struct {
+	ID        string        `json:"id"`
+	Type      string        `json:"type"`
+	Routes    []SearchRoute `json:"routes"`
+	UpdatedAt time.Time     `json:"updated_at"`
+}
+
+// Reindex run states
+const (
+	ReindexRunning = "running"
+	ReindexFailed  = "failed"
+	ReindexBuilt   = "built" // shadow index complete, waiting for cutover
+	ReindexDone    = "done"
+)
+
+// ReindexRun tracks a re-embedding of all chunks so it can resume after a stop
+type ReindexRun struct {
+	ID              string     `json:"id"`
+	Type            string     `json:"type"`
+	Status          string     `json:"status"`
+	TargetModel     string     `json:"target_model"`
+	TargetDimension int        `json:"target_dimension"`
+	EmbeddingURL    string     `json:"embedding_url"`
+	Shadow          bool       `json:"shadow"`
+	Index           string     `json:"index"`
+	Cursor          string     `json:"cursor"` // last chunk key processed in the current pass
+	Total           int        `json:"total"`
+	Processed       int        `json:"processed"`
+	Failed          int        `json:"failed"`
+	Error           string     `json:"error,omitempty"`
+	StartedAt       time.Time  `json:"started_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+	FinishedAt      *time.Time `json:"finished_at,omitempty"`
+}
@@ -0,0 +1,66 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// DocumentVersion is the immutable record of one version of a document's file. Its
+// chunks stay stored (inactive) after it is superseded, so it can be restored.
+type DocumentVersion struct {
+	ID           string    `json:"id"`
+	Type         string    `json:"type"`
+	DocumentID   string    `json:"document_id"`
+	Version      int       `json:"version"`
+	Filename     string    `json:"filename"`
+	DisplayName  string    `json:"display_name"`
+	ObjectKey    string    `json:"object_key"`
+	FileHash     string    `json:"file_hash"`
+	FileSize     int64     `json:"file_size"`
+	ContentType  string    `json:"content_type"`
+	ElementCount int       `json:"element_count"`
+	ChunkCount   int       `json:"chunk_count"`
+	UploadedBy   string    `json:"uploaded_by,omitempty"`
+	ChangeNote   string    `json:"change_note,omitempty"`
+	RestoredFrom int       `json:"restored_from,omitempty"` // version this one was restored from
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// DocumentVersionView is a version record as the API returns it, with the current
+// version marked
+type DocumentVersionView struct {
+	DocumentVersion
+	Current bool `json:"current"`
+}
+
+// DocumentVersionKey returns the key of a version record
+func DocumentVersionKey(documentID string, version int) string {
+	return fmt.Sprintf("docver::%s::%d", documentID, version)
+}
+
+// DocumentVersionCounterKey returns the key of the counter that allocates the
+// version numbers of a document
+func DocumentVersionCounterKey(documentID string) string {
+	return fmt.Sprintf("docver::%s::counter", documentID)
+}
+
+// NewDocumentVersion snapshots the current state of a saved document
+func NewDocumentVersion(doc *Document, chunkCount int, uploadedBy string, changeNote string) DocumentVersion {
+	return DocumentVersion{
+		ID:           DocumentVersionKey(doc.ID, doc.Version),
+		Type:         "document_version",
+		DocumentID:   doc.ID,
+		Version:      doc.Version,
+		Filename:     doc.Filename,
+		DisplayName:  doc.DisplayName,
+		ObjectKey:    doc.ObjectKey,
+		FileHash:     doc.FileHash,
+		FileSize:     doc.FileSize,
+		ContentType:  doc.ContentType,
+		ElementCount: doc.ElementCount,
+		ChunkCount:   chunkCount,
+		UploadedBy:   uploadedBy,
+		ChangeNote:   changeNote,
+		CreatedAt:    doc.UpdatedAt,
+	}
+}
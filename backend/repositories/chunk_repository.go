@@ -0,0 +1,336 @@
+package repositories
+
+import (
+	"bpt-knowledge-center/backend/config"
+	"bpt-knowledge-center/backend/models"
+	"fmt"
+	"os"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+// GetChunkCollectionName returns the collection holding chunk documents
+func GetChunkCollectionName() string {
+	name := os.Getenv("DB_CHUNK_COLLECTION")
+	if name == "" {
+		name = "bpt-chunks"
+	}
+	return name
+}
+
+func getChunkCollection() *gocb.Collection {
+	bucketName := os.Getenv("DB_BUCKET")
+	scopeName := os.Getenv("DB_SCOPE")
+	return config.GetCollection(bucketName, scopeName, GetChunkCollectionName())
+}
+
+// SaveChunks stages each chunk of a document version as its own document. Staged
+// chunks stay out of search until ActivateChunkVersion.
+func SaveChunks(doc *models.Document) error {
+	collection := getChunkCollection()
+
+	for i, dc := range doc.Chunks {
+		chunk := models.NewChunk(doc, i, dc)
+		if _, err := collection.Upsert(chunk.ID, chunk, nil); err != nil {
+			return fmt.Errorf("failed to save chunk %s: %w", chunk.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// GetChunkByID retrieves a single chunk document
+func GetChunkByID(id string) (*models.Chunk, error) {
+	result, err := getChunkCollection().Get(id, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var chunk models.Chunk
+	if err := result.Content(&chunk); err != nil {
+		return nil, err
+	}
+
+	return &chunk, nil
+}
+
+// GetChunksByDocument returns the active chunks of a document in order
+func GetChunksByDocument(documentID string) ([]models.Chunk, error) {
+	bucketName := os.Getenv("DB_BUCKET")
+	scopeName := os.Getenv("DB_SCOPE")
+
+	query := fmt.Sprintf("SELECT c.* FROM `%s`.`%s`.`%s` AS c WHERE c.type = 'chunk' AND c.document_id = $1 AND c.active = true ORDER BY c.seq", bucketName, scopeName, GetChunkCollectionName())
+	rows, err := config.Cluster.Query(query, &gocb.QueryOptions{
+		PositionalParameters: []interface{}{documentID},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var chunks []models.Chunk
+	for rows.Next() {
+		var chunk models.Chunk
+		if err := rows.Row(&chunk); err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, chunk)
+	}
+
+	return chunks, nil
+}
+
+// GetChunksByVersion returns the chunks of one version of a document in order,
+// whether or not that version is active
+func GetChunksByVersion(documentID string, version int) ([]models.Chunk, error) {
+	bucketName := os.Getenv("DB_BUCKET")
+	scopeName := os.Getenv("DB_SCOPE")
+
+	query := fmt.Sprintf("SELECT c.* FROM `%s`.`%s`.`%s` AS c WHERE c.type = 'chunk' AND c.document_id = $1 AND c.version = $2 ORDER BY c.seq", bucketName, scopeName, GetChunkCollectionName())
+	rows, err := config.Cluster.Query(query, &gocb.QueryOptions{
+		PositionalParameters: []interface{}{documentID, version},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var chunks []models.Chunk
+	for rows.Next() {
+		var chunk models.Chunk
+		if err := rows.Row(&chunk); err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, chunk)
+	}
+
+	return chunks, nil
+}
+
+// ActivateChunkVersion makes the chunks of one version of a document searchable and
+// retires the chunks of every other version in a single statement
+func ActivateChunkVersion(documentID string, version int) error {
+	bucketName := os.Getenv("DB_BUCKET")
+	scopeName := os.Getenv("DB_SCOPE")
+
+	query := fmt.Sprintf("UPDATE `%s`.`%s`.`%s` SET active = (IFMISSINGORNULL(version, 0) = $2) WHERE type = 'chunk' AND document_id = $1", bucketName, scopeName, GetChunkCollectionName())
+	_, err := config.Cluster.Query(query, &gocb.QueryOptions{
+		PositionalParameters: []interface{}{documentID, version},
+	})
+
+	return err
+}
+
+// ActivateUnversionedChunks marks chunks written before versioned chunks as active
+func ActivateUnversionedChunks() error {
+	bucketName := os.Getenv("DB_BUCKET")
+	scopeName := os.Getenv("DB_SCOPE")
+
+	query := fmt.Sprintf("UPDATE `%s`.`%s`.`%s` SET active = true WHERE type = 'chunk' AND active IS MISSING", bucketName, scopeName, GetChunkCollectionName())
+	_, err := config.Cluster.Query(query, nil)
+
+	return err
+}
+
+// DeleteChunks removes the chunks of every version of a document
+func DeleteChunks(documentID string) error {
+	bucketName := os.Getenv("DB_BUCKET")
+	scopeName := os.Getenv("DB_SCOPE")
+
+	query := fmt.Sprintf("DELETE FROM `%s`.`%s`.`%s` WHERE type = 'chunk' AND document_id = $1", bucketName, scopeName, GetChunkCollectionName())
+	_, err := config.Cluster.Query(query, &gocb.QueryOptions{
+		PositionalParameters: []interface{}{documentID},
+	})
+
+	return err
+}
+
+// DeleteChunkVersion removes the chunks of one version of a document
+func DeleteChunkVersion(documentID string, version int) error {
+	bucketName := os.Getenv("DB_BUCKET")
+	scopeName := os.Getenv("DB_SCOPE")
+
+	query := fmt.Sprintf("DELETE FROM `%s`.`%s`.`%s` WHERE type = 'chunk' AND document_id = $1 AND version = $2", bucketName, scopeName, GetChunkCollectionName())
+	_, err := config.Cluster.Query(query, &gocb.QueryOptions{
+		PositionalParameters: []interface{}{documentID, version},
+	})
+
+	return err
+}
+
+// UpdateChunkCategory keeps the denormalized category of a document's chunks in sync
+func UpdateChunkCategory(documentID string, category string) error {
+	bucketName := os.Getenv("DB_BUCKET")
+	scopeName := os.Getenv("DB_SCOPE")
+
+	query := fmt.Sprintf("UPDATE `%s`.`%s`.`%s` SET category = $1 WHERE type = 'chunk' AND document_id = $2", bucketName, scopeName, GetChunkCollectionName())
+	_, err := config.Cluster.Query(query, &gocb.QueryOptions{
+		PositionalParameters: []interface{}{category, documentID},
+	})
+
+	return err
+}
+
+// BackfillEmbeddingModel records the model of chunks saved before chunks carried it
+func BackfillEmbeddingModel(model string) error {
+	bucketName := os.Getenv("DB_BUCKET")
+	scopeName := os.Getenv("DB_SCOPE")
+
+	query := fmt.Sprintf("UPDATE `%s`.`%s`.`%s` SET embedding_model = $1, embedding_dimension = ARRAY_LENGTH(vector) WHERE type = 'chunk' AND embedding_model IS MISSING AND IS_ARRAY(vector)", bucketName, scopeName, GetChunkCollectionName())
+	_, err := config.Cluster.Query(query, &gocb.QueryOptions{
+		PositionalParameters: []interface{}{model},
+	})
+
+	return err
+}
+
+// GetChunksToReindex returns up to limit chunks after the cursor key whose slot does
+// not hold a vector of the model yet, ordered by key
+func GetChunksToReindex(slot models.VectorSlot, model string, cursor string, limit int) ([]models.Chunk, error) {
+	bucketName := os.Getenv("DB_BUCKET")
+	scopeName := os.Getenv("DB_SCOPE")
+
+	query := fmt.Sprintf("SELECT META(c).id AS id, c.text FROM `%s`.`%s`.`%s` AS c WHERE c.type = 'chunk' AND IFMISSINGORNULL(c.`%s`, '') != $1 AND META(c).id > $2 ORDER BY META(c).id LIMIT $3", bucketName, scopeName, GetChunkCollectionName(), slot.ModelField)
+	rows, err := config.Cluster.Query(query, &gocb.QueryOptions{
+		PositionalParameters: []interface{}{model, cursor, limit},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var chunks []models.Chunk
+	for rows.Next() {
+		var chunk models.Chunk
+		if err := rows.Row(&chunk); err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, chunk)
+	}
+
+	return chunks, nil
+}
+
+// CountChunksToReindex counts chunks whose slot does not hold a vector of the model
+func CountChunksToReindex(slot models.VectorSlot, model string) (int, error) {
+	bucketName := os.Getenv("DB_BUCKET")
+	scopeName := os.Getenv("DB_SCOPE")
+
+	query := fmt.Sprintf("SELECT RAW COUNT(*) FROM `%s`.`%s`.`%s` AS c WHERE c.type = 'chunk' AND IFMISSINGORNULL(c.`%s`, '') != $1", bucketName, scopeName, GetChunkCollectionName(), slot.ModelField)
+	return countQuery(query, model)
+}
+
+// CountChunks counts all chunk documents
+func CountChunks() (int, error) {
+	bucketName := os.Getenv("DB_BUCKET")
+	scopeName := os.Getenv("DB_SCOPE")
+
+	query := fmt.Sprintf("SELECT RAW COUNT(*) FROM `%s`.`%s`.`%s` AS c WHERE c.type = 'chunk'", bucketName, scopeName, GetChunkCollectionName())
+	return countQuery(query)
+}
+
+// CountDocumentChunks counts the chunks of one version of a document, including
+// chunks saved before versioned chunks
+func CountDocumentChunks(documentID string, version int) (int, error) {
+	bucketName := os.Getenv("DB_BUCKET")
+	scopeName := os.Getenv("DB_SCOPE")
+
+	query := fmt.Sprintf("SELECT RAW COUNT(*) FROM `%s`.`%s`.`%s` AS c WHERE c.type = 'chunk' AND c.document_id = $1 AND IFMISSINGORNULL(c.version, $2) = $2", bucketName, scopeName, GetChunkCollectionName())
+	return countQuery(query, documentID, version)
+}
+
+// SetChunkEmbedding writes a vector and its model into one slot of a chunk
+func SetChunkEmbedding(id string, slot models.VectorSlot, vector []float32, model string) error {
+	specs := []gocb.MutateInSpec{
+		gocb.UpsertSpec(slot.VectorField, vector, nil),
+		gocb.UpsertSpec(slot.ModelField, model, nil),
+		gocb.UpsertSpec(slot.DimensionField, len(vector), nil),
+	}
+
+	_, err := getChunkCollection().MutateIn(id, specs, nil)
+	return err
+}
+
+// CopyChunkVectorsToShadow mirrors the primary vectors of a document version into the
+// shadow slot, for chunks saved while the shadow index serves their model
+func CopyChunkVectorsToShadow(documentID string, version int) error {
+	bucketName := os.Getenv("DB_BUCKET")
+	scopeName := os.Getenv("DB_SCOPE")
+
+	query := fmt.Sprintf("UPDATE `%s`.`%s`.`%s` SET shadow_vector = vector, shadow_model = embedding_model, shadow_dimension = embedding_dimension WHERE type = 'chunk' AND document_id = $1 AND version = $2", bucketName, scopeName, GetChunkCollectionName())
+	_, err := config.Cluster.Query(query, &gocb.QueryOptions{
+		PositionalParameters: []interface{}{documentID, version},
+	})
+
+	return err
+}
+
+// PromoteShadowVectors moves the shadow vectors of a model into the primary slot
+func PromoteShadowVectors(model string) error {
+	bucketName := os.Getenv("DB_BUCKET")
+	scopeName := os.Getenv("DB_SCOPE")
+
+	query := fmt.Sprintf("UPDATE `%s`.`%s`.`%s` SET vector = shadow_vector, embedding_model = shadow_model, embedding_dimension = shadow_dimension WHERE type = 'chunk' AND shadow_model = $1", bucketName, scopeName, GetChunkCollectionName())
+	_, err := config.Cluster.Query(query, &gocb.QueryOptions{
+		PositionalParameters: []interface{}{model},
+	})
+
+	return err
+}
+
+// ClearShadowVectors empties the shadow slot of every chunk
+func ClearShadowVectors() error {
+	bucketName := os.Getenv("DB_BUCKET")
+	scopeName := os.Getenv("DB_SCOPE")
+
+	query := fmt.Sprintf("UPDATE `%s`.`%s`.`%s` UNSET shadow_vector, shadow_model, shadow_dimension WHERE type = 'chunk' AND shadow_model IS NOT MISSING", bucketName, scopeName, GetChunkCollectionName())
+	_, err := config.Cluster.Query(query, nil)
+
+	return err
+}
+
+func countQuery(query string, args ...interface{}) (int, error) {
+	rows, err := config.Cluster.Query(query, &gocb.QueryOptions{
+		PositionalParameters: args,
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var count int
+	for rows.Next() {
+		if err := rows.Row(&count); err != nil {
+			return 0, err
+		}
+	}
+
+	return count, rows.Err()
+}
+
+// GetChunkRange returns the chunks of one version of a document with a sequence
+// number between from and to, in order
+func GetChunkRange(documentID string, version int, from int, to int) ([]models.Chunk, error) {
+	bucketName := os.Getenv("DB_BUCKET")
+	scopeName := os.Getenv("DB_SCOPE")
+
+	query := fmt.Sprintf("SELECT c.* FROM `%s`.`%s`.`%s` AS c WHERE c.type = 'chunk' AND c.document_id = $1 AND IFMISSINGORNULL(c.version, 0) = $2 AND c.seq BETWEEN $3 AND $4 ORDER BY c.seq", bucketName, scopeName, GetChunkCollectionName())
+	rows, err := config.Cluster.Query(query, &gocb.QueryOptions{
+		PositionalParameters: []interface{}{documentID, version, from, to},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var chunks []models.Chunk
+	for rows.Next() {
+		var chunk models.Chunk
+		if err := rows.Row(&chunk); err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, chunk)
+	}
+
+	return chunks, nil
+}
@@ -0,0 +1,116 @@
+package repositories
+
+import (
+	"bpt-knowledge-center/backend/config"
+	"bpt-knowledge-center/backend/models"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/couchbase/gocb/v2"
+	"github.com/google/uuid"
+)
+
+// getConversationCollectionName returns the collection holding chat sessions
+func getConversationCollectionName() string {
+	name := os.Getenv("DB_CONVERSATION_COLLECTION")
+	if name == "" {
+		name = "bpt-conversations"
+	}
+	return name
+}
+
+func getConversationCollection() *gocb.Collection {
+	bucketName := os.Getenv("DB_BUCKET")
+	scopeName := os.Getenv("DB_SCOPE")
+	return config.GetCollection(bucketName, scopeName, getConversationCollectionName())
+}
+
+// CreateConversation persists a new, empty conversation
+func CreateConversation(conv *models.Conversation) error {
+	if conv.ID == "" {
+		conv.ID = "conv::" + uuid.New().String()
+	}
+	conv.Type = "conversation"
+
+	now := time.Now()
+	conv.CreatedAt = now
+	conv.UpdatedAt = now
+	if conv.Messages == nil {
+		conv.Messages = []models.ChatMessage{}
+	}
+
+	_, err := getConversationCollection().Insert(conv.ID, conv, nil)
+	if err != nil {
+		log.Printf("Failed to save conversation to Couchbase: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// GetConversationByID retrieves a conversation with all of its messages
+func GetConversationByID(id string) (*models.Conversation, error) {
+	result, err := getConversationCollection().Get(id, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var conv models.Conversation
+	if err := result.Content(&conv); err != nil {
+		return nil, err
+	}
+
+	return &conv, nil
+}
+
+// GetAllConversations lists conversations without their messages, most recent first
+func GetAllConversations() ([]models.Conversation, error) {
+	bucketName := os.Getenv("DB_BUCKET")
+	scopeName := os.Getenv("DB_SCOPE")
+
+	query := fmt.Sprintf("SELECT id, type, title, created_at, updated_at FROM `%s`.`%s`.`%s` WHERE type = 'conversation' ORDER BY updated_at DESC", bucketName, scopeName, getConversationCollectionName())
+	rows, err := config.Cluster.Query(query, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var conversations []models.Conversation
+	for rows.Next() {
+		var conv models.Conversation
+		if err := rows.Row(&conv); err != nil {
+			return nil, err
+		}
+		conversations = append(conversations, conv)
+	}
+
+	return conversations, nil
+}
+
+// AppendMessages adds messages to the end of a conversation and bumps updated_at
+func AppendMessages(id string, messages ...models.ChatMessage) error {
+	specs := []gocb.MutateInSpec{
+		gocb.ArrayAppendSpec("messages", messages, &gocb.ArrayAppendSpecOptions{HasMultiple: true}),
+		gocb.UpsertSpec("updated_at", time.Now(), nil),
+	}
+
+	_, err := getConversationCollection().MutateIn(id, specs, nil)
+	return err
+}
+
+// UpdateConversationTitle sets the title of a conversation
+func UpdateConversationTitle(id string, title string) error {
+	specs := []gocb.MutateInSpec{
+		gocb.UpsertSpec("title", title, nil),
+	}
+
+	_, err := getConversationCollection().MutateIn(id, specs, nil)
+	return err
+}
+
+func DeleteConversation(id string) error {
+	_, err := getConversationCollection().Remove(id, nil)
+	return err
+}
@@ -3,16 +3,20 @@ package repositories
 import (
 	"bpt-knowledge-center/backend/config"
 	"bpt-knowledge-center/backend/models"
+	"errors"
 	"fmt"
 	"log"
 	"os"
-	"time"
 
 	"github.com/couchbase/gocb/v2"
 	"github.com/google/uuid"
 )
 
-// SaveDocument persists the parsed document into Couchbase
+// ErrStaleVersion is returned when a newer version of the document was saved first
+var ErrStaleVersion = errors.New("a newer version of the document is already saved")
+
+// SaveDocument persists the parsed document into Couchbase, storing its chunks as
+// separate chunk documents
 func SaveDocument(doc *models.Document) error {
 	bucketName := os.Getenv("DB_BUCKET")
 	scopeName := os.Getenv("DB_SCOPE")
@@ -35,16 +39,110 @@ func SaveDocument(doc *models.Document) error {
 		doc.DisplayName = doc.Filename
 	}
 
-	// Upsert (Insert or Update)
-	_, err := collection.Upsert(doc.ID, doc, &gocb.UpsertOptions{})
+	stored := *doc
+	stored.Chunks = nil
+
+	// Without chunks only the record changes
+	if doc.Chunks == nil {
+		if _, err := collection.Upsert(doc.ID, stored, &gocb.UpsertOptions{}); err != nil {
+			log.Printf("Failed to save document to Couchbase: %v", err)
+			return err
+		}
+		return nil
+	}
+
+	// A non-nil slice replaces the searchable chunks, as stage-then-swap: the new
+	// version's chunks are written inactive, then the record is switched, then the
+	// chunks are activated. Any failure puts the previous version back in place.
+	// The record is switched with a CAS so a version never replaces a newer one.
+	previous, cas, err := getDocumentWithCas(collection, doc.ID)
+	if err != nil && !errors.Is(err, gocb.ErrDocumentNotFound) {
+		return err
+	}
+	if previous != nil && previous.Version > doc.Version {
+		return ErrStaleVersion
+	}
+
+	discardStaged := func() {
+		if err := DeleteChunkVersion(doc.ID, doc.Version); err != nil {
+			log.Printf("Warning: Failed to remove staged chunks of %s v%d: %v", doc.ID, doc.Version, err)
+		}
+	}
+
+	// Leftovers of an interrupted attempt at the same version would otherwise go live
+	if previous == nil || previous.Version != doc.Version {
+		discardStaged()
+	}
+
+	if err := SaveChunks(doc); err != nil {
+		log.Printf("Failed to save chunks to Couchbase: %v", err)
+		discardStaged()
+		return err
+	}
+
+	// Another version may have been saved meanwhile; retry against it unless it is newer
+	for {
+		if previous == nil {
+			_, err = collection.Insert(doc.ID, stored, nil)
+		} else {
+			_, err = collection.Replace(doc.ID, stored, &gocb.ReplaceOptions{Cas: cas})
+		}
+		if !errors.Is(err, gocb.ErrCasMismatch) && !errors.Is(err, gocb.ErrDocumentExists) {
+			break
+		}
+		if previous, cas, err = getDocumentWithCas(collection, doc.ID); err != nil {
+			break
+		}
+		if previous.Version > doc.Version {
+			err = ErrStaleVersion
+			break
+		}
+	}
 	if err != nil {
-		log.Printf("Failed to save document to Couchbase: %v", err)
+		log.Printf("Failed to save document %s v%d: %v", doc.ID, doc.Version, err)
+		discardStaged()
 		return err
 	}
 
+	if err := ActivateChunkVersion(doc.ID, doc.Version); err != nil {
+		log.Printf("Failed to activate chunks of %s v%d: %v", doc.ID, doc.Version, err)
+		if previous != nil && previous.Version != doc.Version {
+			if _, err := collection.Upsert(previous.ID, previous, nil); err != nil {
+				log.Printf("Failed to restore %s v%d: %v", previous.ID, previous.Version, err)
+			} else if err := ActivateChunkVersion(previous.ID, previous.Version); err != nil {
+				log.Printf("Failed to reactivate chunks of %s v%d: %v", previous.ID, previous.Version, err)
+			}
+			discardStaged()
+		}
+		return err
+	}
+
+	// A newer version that switched the record before this activation ran has its
+	// chunks activated again, so the record and the live chunks agree
+	if current, _, err := getDocumentWithCas(collection, doc.ID); err == nil && current.Version != doc.Version {
+		if err := ActivateChunkVersion(doc.ID, current.Version); err != nil {
+			log.Printf("Failed to reactivate chunks of %s v%d: %v", doc.ID, current.Version, err)
+		}
+		return ErrStaleVersion
+	}
+
 	return nil
 }
 
+// getDocumentWithCas reads a document record together with its CAS value
+func getDocumentWithCas(collection *gocb.Collection, id string) (*models.Document, gocb.Cas, error) {
+	result, err := collection.Get(id, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var doc models.Document
+	if err := result.Content(&doc); err != nil {
+		return nil, 0, err
+	}
+	return &doc, result.Cas(), nil
+}
+
 func UpdateDocumentMetadata(id string, displayName string, category string, description string) error {
 	bucketName := os.Getenv("DB_BUCKET")
 	scopeName := os.Getenv("DB_SCOPE")
@@ -55,8 +153,11 @@ func UpdateDocumentMetadata(id string, displayName string, category string, desc
 	_, err := config.Cluster.Query(query, &gocb.QueryOptions{
 		PositionalParameters: []interface{}{displayName, category, description, id},
 	})
+	if err != nil {
+		return err
+	}
 
-	return err
+	return UpdateChunkCategory(id, category)
 }
 
 // UpdateDocumentName updates the display name of a document
@@ -96,8 +197,19 @@ func GetDocumentByID(id string) (*models.Document, error) {
 	return &doc, nil
 }
 
-// IncrementDocumentVersion increments version and updates the document
-func IncrementDocumentVersion(id string) (int, error) {
+func DeleteDocument(id string) error {
+	bucketName := os.Getenv("DB_BUCKET")
+	scopeName := os.Getenv("DB_SCOPE")
+	collection := config.GetCollection(bucketName, scopeName, "bpt-docs")
+
+	if _, err := collection.Remove(id, nil); err != nil {
+		return err
+	}
+
+	return DeleteChunks(id)
+}
+
+func GetAllDocuments() ([]models.Document, error) {
 	bucketName := os.Getenv("DB_BUCKET")
 	scopeName := os.Getenv("DB_SCOPE")
 	collectionName := os.Getenv("DB_COLLECTION")
@@ -105,43 +217,76 @@ func IncrementDocumentVersion(id string) (int, error) {
 		collectionName = "bpt-docs"
 	}
 
-	// Get current version
-	doc, err := GetDocumentByID(id)
+	// Query with new fields
+	query := fmt.Sprintf("SELECT id, filename, display_name, uploaded_at, updated_at, element_count, version, category, description FROM `%s`.`%s`.`%s` WHERE type = 'document' ORDER BY uploaded_at DESC", bucketName, scopeName, collectionName)
+	rows, err := config.Cluster.Query(query, nil)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
+	defer rows.Close()
 
-	newVersion := doc.Version + 1
-	now := time.Now()
+	var documents []models.Document
+	for rows.Next() {
+		var doc models.Document
+		if err := rows.Row(&doc); err != nil {
+			return nil, err
+		}
+		documents = append(documents, doc)
+	}
 
-	query := fmt.Sprintf("UPDATE `%s`.`%s`.`%s` SET version = $1, updated_at = $2 WHERE id = $3", bucketName, scopeName, collectionName)
+	return documents, nil
+}
 
-	_, err = config.Cluster.Query(query, &gocb.QueryOptions{
-		PositionalParameters: []interface{}{newVersion, now, id},
+// GetDocumentByHash returns the document whose current file has the given SHA-256,
+// or nil if there is none
+func GetDocumentByHash(hash string) (*models.Document, error) {
+	bucketName := os.Getenv("DB_BUCKET")
+	scopeName := os.Getenv("DB_SCOPE")
+
+	query := fmt.Sprintf("SELECT d.* FROM `%s`.`%s`.`bpt-docs` AS d WHERE d.type = 'document' AND d.file_hash = $1 ORDER BY d.uploaded_at ASC LIMIT 1", bucketName, scopeName)
+	rows, err := config.Cluster.Query(query, &gocb.QueryOptions{
+		PositionalParameters: []interface{}{hash},
 	})
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var doc models.Document
+		if err := rows.Row(&doc); err != nil {
+			return nil, err
+		}
+		return &doc, nil
+	}
 
-	return newVersion, err
+	return nil, rows.Err()
 }
 
-func DeleteDocument(id string) error {
+// AddDocumentAlias records another filename under which the document was uploaded
+func AddDocumentAlias(id string, filename string) error {
 	bucketName := os.Getenv("DB_BUCKET")
 	scopeName := os.Getenv("DB_SCOPE")
 	collection := config.GetCollection(bucketName, scopeName, "bpt-docs")
 
-	_, err := collection.Remove(id, nil)
+	specs := []gocb.MutateInSpec{
+		gocb.ArrayAddUniqueSpec("aliases", filename, &gocb.ArrayAddUniqueSpecOptions{CreatePath: true}),
+	}
+
+	_, err := collection.MutateIn(id, specs, nil)
+	if errors.Is(err, gocb.ErrPathExists) {
+		return nil
+	}
 	return err
 }
 
-func GetAllDocuments() ([]models.Document, error) {
+// GetDocumentFileRefs returns the ID, filename, version and object key of every
+// document, for matching records against object storage
+func GetDocumentFileRefs() ([]models.Document, error) {
 	bucketName := os.Getenv("DB_BUCKET")
 	scopeName := os.Getenv("DB_SCOPE")
-	collectionName := os.Getenv("DB_COLLECTION")
-	if collectionName == "" {
-		collectionName = "bpt-docs"
-	}
 
-	// Query with new fields
-	query := fmt.Sprintf("SELECT id, filename, display_name, uploaded_at, updated_at, element_count, version, category, description FROM `%s`.`%s`.`%s` WHERE type = 'document' ORDER BY uploaded_at DESC", bucketName, scopeName, collectionName)
+	query := fmt.Sprintf("SELECT META(d).id, d.filename, d.version, d.object_key FROM `%s`.`%s`.`bpt-docs` AS d WHERE d.type = 'document'", bucketName, scopeName)
 	rows, err := config.Cluster.Query(query, nil)
 	if err != nil {
 		return nil, err
@@ -159,3 +304,27 @@ func GetAllDocuments() ([]models.Document, error) {
 
 	return documents, nil
 }
+
+// GetLegacyDocumentIDs returns documents that still embed their chunks in a nested array
+func GetLegacyDocumentIDs() ([]string, error) {
+	bucketName := os.Getenv("DB_BUCKET")
+	scopeName := os.Getenv("DB_SCOPE")
+
+	query := fmt.Sprintf("SELECT RAW META(d).id FROM `%s`.`%s`.`bpt-docs` AS d WHERE d.type = 'document' AND IS_ARRAY(d.chunks)", bucketName, scopeName)
+	rows, err := config.Cluster.Query(query, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Row(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
@@ -0,0 +1,137 @@
+package repositories
+
+import (
+	"bpt-knowledge-center/backend/config"
+	"bpt-knowledge-center/backend/models"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+// getJobCollectionName returns the collection holding ingestion jobs
+func getJobCollectionName() string {
+	name := os.Getenv("DB_JOB_COLLECTION")
+	if name == "" {
+		name = "bpt-jobs"
+	}
+	return name
+}
+
+func getJobCollection() *gocb.Collection {
+	bucketName := os.Getenv("DB_BUCKET")
+	scopeName := os.Getenv("DB_SCOPE")
+	return config.GetCollection(bucketName, scopeName, getJobCollectionName())
+}
+
+// SaveJob persists the current state of an ingestion job
+func SaveJob(job *models.IngestionJob) error {
+	job.Type = "job"
+	job.UpdatedAt = time.Now()
+	if job.CreatedAt.IsZero() {
+		job.CreatedAt = job.UpdatedAt
+	}
+
+	_, err := getJobCollection().Upsert(job.ID, job, nil)
+	if err != nil {
+		log.Printf("Failed to save job %s: %v", job.ID, err)
+		return err
+	}
+
+	return nil
+}
+
+// GetJobByID retrieves a single ingestion job
+func GetJobByID(id string) (*models.IngestionJob, error) {
+	result, err := getJobCollection().Get(id, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var job models.IngestionJob
+	if err := result.Content(&job); err != nil {
+		return nil, err
+	}
+
+	return &job, nil
+}
+
+// GetUnfinishedJobs returns jobs that were queued or running, oldest first
+func GetUnfinishedJobs() ([]models.IngestionJob, error) {
+	bucketName := os.Getenv("DB_BUCKET")
+	scopeName := os.Getenv("DB_SCOPE")
+
+	query := fmt.Sprintf("SELECT j.* FROM `%s`.`%s`.`%s` AS j WHERE j.type = 'job' AND j.status IN $1 ORDER BY j.created_at ASC", bucketName, scopeName, getJobCollectionName())
+	rows, err := config.Cluster.Query(query, &gocb.QueryOptions{
+		PositionalParameters: []interface{}{[]string{models.JobQueued, models.JobParsing, models.JobEmbedding}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []models.IngestionJob
+	for rows.Next() {
+		var job models.IngestionJob
+		if err := rows.Row(&job); err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+
+	return jobs, nil
+}
+
+// GetJobsByDocument returns every ingestion job of a document
+func GetJobsByDocument(documentID string) ([]models.IngestionJob, error) {
+	return queryJobs("j.document_id = $1", documentID)
+}
+
+// GetRetainedJobs returns jobs that still need their temp files: unfinished jobs and
+// failed jobs that can be retried
+func GetRetainedJobs() ([]models.IngestionJob, error) {
+	return queryJobs("j.status != $1", models.JobSaved)
+}
+
+// GetPendingJobByHash returns a queued or running job for a file with the given
+// SHA-256, or nil if there is none
+func GetPendingJobByHash(hash string) (*models.IngestionJob, error) {
+	jobs, err := queryJobs("j.file_hash = $1 AND j.status IN $2", hash, []string{models.JobQueued, models.JobParsing, models.JobEmbedding})
+	if err != nil || len(jobs) == 0 {
+		return nil, err
+	}
+	return &jobs[0], nil
+}
+
+// DeleteJob removes an ingestion job record
+func DeleteJob(id string) error {
+	_, err := getJobCollection().Remove(id, nil)
+	return err
+}
+
+func queryJobs(where string, args ...interface{}) ([]models.IngestionJob, error) {
+	bucketName := os.Getenv("DB_BUCKET")
+	scopeName := os.Getenv("DB_SCOPE")
+
+	query := fmt.Sprintf("SELECT j.* FROM `%s`.`%s`.`%s` AS j WHERE j.type = 'job' AND %s", bucketName, scopeName, getJobCollectionName(), where)
+	rows, err := config.Cluster.Query(query, &gocb.QueryOptions{
+		PositionalParameters: args,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []models.IngestionJob
+	for rows.Next() {
+		var job models.IngestionJob
+		if err := rows.Row(&job); err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+
+	return jobs, nil
+}
@@ -0,0 +1,86 @@
+package repositories
+
+import (
+	"bpt-knowledge-center/backend/config"
+	"bpt-knowledge-center/backend/models"
+	"errors"
+	"os"
+	"time"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+// Keys of the singleton records in the meta collection
+const (
+	searchRoutingKey = "search_routing"
+	reindexRunKey    = "reindex_run"
+)
+
+// getMetaCollectionName returns the collection holding service-wide state
+func getMetaCollectionName() string {
+	name := os.Getenv("DB_META_COLLECTION")
+	if name == "" {
+		name = "bpt-meta"
+	}
+	return name
+}
+
+func getMetaCollection() *gocb.Collection {
+	bucketName := os.Getenv("DB_BUCKET")
+	scopeName := os.Getenv("DB_SCOPE")
+	return config.GetCollection(bucketName, scopeName, getMetaCollectionName())
+}
+
+// GetSearchRouting returns the stored search routes, or nil if none were saved yet
+func GetSearchRouting() (*models.SearchRouting, error) {
+	result, err := getMetaCollection().Get(searchRoutingKey, nil)
+	if errors.Is(err, gocb.ErrDocumentNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var routing models.SearchRouting
+	if err := result.Content(&routing); err != nil {
+		return nil, err
+	}
+
+	return &routing, nil
+}
+
+// SaveSearchRouting replaces the stored search routes
+func SaveSearchRouting(routing *models.SearchRouting) error {
+	routing.ID = searchRoutingKey
+	routing.Type = "search_routing"
+	routing.UpdatedAt = time.Now()
+
+	_, err := getMetaCollection().Upsert(routing.ID, routing, nil)
+	return err
+}
+
+// GetReindexRun returns the latest reindex run, or nil if there was none
+func GetReindexRun() (*models.ReindexRun, error) {
+	result, err := getMetaCollection().Get(reindexRunKey, nil)
+	if errors.Is(err, gocb.ErrDocumentNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var run models.ReindexRun
+	if err := result.Content(&run); err != nil {
+		return nil, err
+	}
+
+	return &run, nil
+}
+
+// SaveReindexRun records the progress of a reindex run
+func SaveReindexRun(run *models.ReindexRun) error {
+	run.ID = reindexRunKey
+	run.Type = "reindex_run"
+	run.UpdatedAt = time.Now()
+
+	_, err := getMetaCollection().Upsert(run.ID, run, nil)
+	return err
+}
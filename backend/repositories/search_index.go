@@ -0,0 +1,150 @@
+package repositories
+
+import (
+	"bpt-knowledge-center/backend/config"
+	"bpt-knowledge-center/backend/models"
+	"errors"
+	"os"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+// GetSearchIndexName returns the name of the scoped chunk search index
+func GetSearchIndexName() string {
+	name := os.Getenv("SEARCH_INDEX")
+	if name == "" {
+		name = "knowledge_vector_search"
+	}
+	return name
+}
+
+// GetShadowSearchIndexName returns the name of the index a reindex builds into
+func GetShadowSearchIndexName() string {
+	return GetSearchIndexName() + "_shadow"
+}
+
+// ChunkSearchIndexDefinition describes the search index over chunk documents: the
+// vector of one slot for similarity search, the model that produced it, plus stored
+// fields returned with each hit.
+func ChunkSearchIndexDefinition(name string, slot models.VectorSlot, dims int) gocb.SearchIndex {
+	bucketName := os.Getenv("DB_BUCKET")
+	scopeName := os.Getenv("DB_SCOPE")
+
+	storedKeyword := func(fieldName string) map[string]interface{} {
+		return map[string]interface{}{
+			"fields": []interface{}{map[string]interface{}{
+				"name": fieldName, "type": "text", "analyzer": "keyword",
+				"index": true, "store": true,
+			}},
+		}
+	}
+
+	return gocb.SearchIndex{
+		Name:       name,
+		Type:       "fulltext-index",
+		SourceType: "gocbcore",
+		SourceName: bucketName,
+		PlanParams: map[string]interface{}{
+			"indexPartitions": 1,
+		},
+		Params: map[string]interface{}{
+			"doc_config": map[string]interface{}{
+				"mode":       "scope.collection.type_field",
+				"type_field": "type",
+			},
+			"mapping": map[string]interface{}{
+				"default_mapping": map[string]interface{}{"enabled": false},
+				"types": map[string]interface{}{
+					scopeName + "." + GetChunkCollectionName(): map[string]interface{}{
+						"enabled": true,
+						"dynamic": false,
+						"properties": map[string]interface{}{
+							slot.VectorField: map[string]interface{}{
+								"fields": []interface{}{map[string]interface{}{
+									"name": slot.VectorField, "type": "vector", "dims": dims,
+									"similarity": "dot_product", "vector_index_optimized_for": "recall",
+									"index": true,
+								}},
+							},
+							"text": map[string]interface{}{
+								"fields": []interface{}{map[string]interface{}{
+									"name": "text", "type": "text", "analyzer": "standard",
+									"index": true, "store": true,
+								}},
+							},
+							"page": map[string]interface{}{
+								"fields": []interface{}{map[string]interface{}{
+									"name": "page", "type": "number", "index": true, "store": true,
+								}},
+							},
+							"active": map[string]interface{}{
+								"fields": []interface{}{map[string]interface{}{
+									"name": "active", "type": "boolean", "index": true,
+								}},
+							},
+							"version": map[string]interface{}{
+								"fields": []interface{}{map[string]interface{}{
+									"name": "version", "type": "number", "index": true, "store": true,
+								}},
+							},
+							"uploaded_at": map[string]interface{}{
+								"fields": []interface{}{map[string]interface{}{
+									"name": "uploaded_at", "type": "datetime", "index": true,
+								}},
+							},
+							"document_id":   storedKeyword("document_id"),
+							"chunk_id":      storedKeyword("chunk_id"),
+							"source":        storedKeyword("source"),
+							"category":      storedKeyword("category"),
+							"content_type":  storedKeyword("content_type"),
+							slot.ModelField: storedKeyword(slot.ModelField),
+						},
+					},
+				},
+			},
+			"store": map[string]interface{}{
+				"indexType": "scorch",
+			},
+		},
+	}
+}
+
+// EnsureChunkSearchIndex creates or updates the primary chunk search index
+func EnsureChunkSearchIndex(dims int) error {
+	return EnsureSearchIndex(GetSearchIndexName(), models.PrimarySlot, dims)
+}
+
+// EnsureSearchIndex creates or updates a chunk search index over one vector slot
+func EnsureSearchIndex(name string, slot models.VectorSlot, dims int) error {
+	manager := searchIndexManager()
+
+	index := ChunkSearchIndexDefinition(name, slot, dims)
+
+	// Updates must carry the UUID of the existing definition
+	if existing, err := manager.GetIndex(index.Name, nil); err == nil {
+		index.UUID = existing.UUID
+	}
+
+	return manager.UpsertIndex(index, nil)
+}
+
+// DropSearchIndex removes a chunk search index; a missing index is not an error
+func DropSearchIndex(name string) error {
+	err := searchIndexManager().DropIndex(name, nil)
+	if errors.Is(err, gocb.ErrIndexNotFound) {
+		return nil
+	}
+	return err
+}
+
+// IndexedDocumentCount reports how many documents a search index has ingested
+func IndexedDocumentCount(name string) (int, error) {
+	count, err := searchIndexManager().GetIndexedDocumentsCount(name, nil)
+	return int(count), err
+}
+
+func searchIndexManager() *gocb.ScopeSearchIndexManager {
+	bucketName := os.Getenv("DB_BUCKET")
+	scopeName := os.Getenv("DB_SCOPE")
+	return config.Cluster.Bucket(bucketName).Scope(scopeName).SearchIndexes()
+}
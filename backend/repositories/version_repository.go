@@ -0,0 +1,143 @@
+package repositories
+
+import (
+	"bpt-knowledge-center/backend/config"
+	"bpt-knowledge-center/backend/models"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+// getVersionCollectionName returns the collection holding document version records
+func getVersionCollectionName() string {
+	name := os.Getenv("DB_VERSION_COLLECTION")
+	if name == "" {
+		name = "bpt-versions"
+	}
+	return name
+}
+
+func getVersionCollection() *gocb.Collection {
+	bucketName := os.Getenv("DB_BUCKET")
+	scopeName := os.Getenv("DB_SCOPE")
+	return config.GetCollection(bucketName, scopeName, getVersionCollectionName())
+}
+
+// CreateDocumentVersion stores a version record. Records are never overwritten, so
+// saving the same version twice keeps the first one.
+func CreateDocumentVersion(version *models.DocumentVersion) error {
+	version.ID = models.DocumentVersionKey(version.DocumentID, version.Version)
+	version.Type = "document_version"
+
+	_, err := getVersionCollection().Insert(version.ID, version, nil)
+	if errors.Is(err, gocb.ErrDocumentExists) {
+		return nil
+	} else if err != nil {
+		log.Printf("Failed to save version %s: %v", version.ID, err)
+		return err
+	}
+
+	return nil
+}
+
+// NextDocumentVersion atomically allocates the next version number of a document, so
+// concurrent uploads never get the same one. The counter starts after current, the
+// version of the document when it is first used; numbers of uploads that never
+// finished are not handed out again.
+func NextDocumentVersion(documentID string, current int) (int, error) {
+	result, err := getVersionCollection().Binary().Increment(models.DocumentVersionCounterKey(documentID), &gocb.IncrementOptions{
+		Initial: int64(current + 1),
+		Delta:   1,
+	})
+	if err != nil {
+		return 0, err
+	}
+	return int(result.Content()), nil
+}
+
+// GetDocumentVersion retrieves the record of one version of a document
+func GetDocumentVersion(documentID string, version int) (*models.DocumentVersion, error) {
+	result, err := getVersionCollection().Get(models.DocumentVersionKey(documentID, version), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var record models.DocumentVersion
+	if err := result.Content(&record); err != nil {
+		return nil, err
+	}
+
+	return &record, nil
+}
+
+// GetDocumentVersions returns the version records of a document, newest first
+func GetDocumentVersions(documentID string) ([]models.DocumentVersion, error) {
+	bucketName := os.Getenv("DB_BUCKET")
+	scopeName := os.Getenv("DB_SCOPE")
+
+	query := fmt.Sprintf("SELECT v.* FROM `%s`.`%s`.`%s` AS v WHERE v.type = 'document_version' AND v.document_id = $1 ORDER BY v.version DESC", bucketName, scopeName, getVersionCollectionName())
+	rows, err := config.Cluster.Query(query, &gocb.QueryOptions{
+		PositionalParameters: []interface{}{documentID},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var versions []models.DocumentVersion
+	for rows.Next() {
+		var record models.DocumentVersion
+		if err := rows.Row(&record); err != nil {
+			return nil, err
+		}
+		versions = append(versions, record)
+	}
+
+	return versions, nil
+}
+
+// DeleteDocumentVersions removes the version records and version counter of a document
+func DeleteDocumentVersions(documentID string) error {
+	bucketName := os.Getenv("DB_BUCKET")
+	scopeName := os.Getenv("DB_SCOPE")
+
+	query := fmt.Sprintf("DELETE FROM `%s`.`%s`.`%s` WHERE type = 'document_version' AND document_id = $1", bucketName, scopeName, getVersionCollectionName())
+	if _, err := config.Cluster.Query(query, &gocb.QueryOptions{
+		PositionalParameters: []interface{}{documentID},
+	}); err != nil {
+		return err
+	}
+
+	_, err := getVersionCollection().Remove(models.DocumentVersionCounterKey(documentID), nil)
+	if errors.Is(err, gocb.ErrDocumentNotFound) {
+		return nil
+	}
+	return err
+}
+
+// GetVersionObjectKeys returns the storage keys that version records point to
+func GetVersionObjectKeys() ([]string, error) {
+	bucketName := os.Getenv("DB_BUCKET")
+	scopeName := os.Getenv("DB_SCOPE")
+
+	query := fmt.Sprintf("SELECT RAW v.object_key FROM `%s`.`%s`.`%s` AS v WHERE v.type = 'document_version' AND v.object_key IS VALUED", bucketName, scopeName, getVersionCollectionName())
+	rows, err := config.Cluster.Query(query, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Row(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
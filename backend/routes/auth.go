@@ -0,0 +1,31 @@
+package routes
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// requireMaintenanceToken guards the maintenance endpoints with the bearer token in
+// MAINTENANCE_TOKEN. Without a token configured the endpoints are disabled, so they
+// are never exposed by accident.
+func requireMaintenanceToken() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := os.Getenv("MAINTENANCE_TOKEN")
+		if token == "" {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Maintenance endpoints are disabled"})
+			return
+		}
+
+		given, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -25,11 +25,34 @@ func SetupRouter() *gin.Engine {
 	{
 		api.POST("/documents/upload", controllers.UploadDocument)
 		api.GET("/documents", controllers.GetDocuments)
+		api.GET("/documents/:id/download", controllers.DownloadDocument)
+		api.GET("/documents/:id/versions", controllers.GetDocumentVersions)
+		api.GET("/documents/:id/diff", controllers.DiffDocumentVersions)
+		api.POST("/documents/:id/versions/:v/restore", controllers.RestoreDocumentVersion)
 		api.PUT("/documents/:id", controllers.UpdateDocument)
 		api.PATCH("/documents/:id/name", controllers.UpdateDocumentName)
 		api.DELETE("/documents/:id", controllers.DeleteDocument)
 
+		api.GET("/jobs/:id", controllers.GetJob)
+		api.POST("/jobs/:id/retry", controllers.RetryJob)
+
 		api.POST("/chat", controllers.HandleChat)
+		api.POST("/chat/stream", controllers.HandleChatStream)
+		api.POST("/search", controllers.HandleSearch)
+
+		api.POST("/conversations", controllers.CreateConversation)
+		api.GET("/conversations", controllers.GetConversations)
+		api.GET("/conversations/:id", controllers.GetConversation)
+		api.DELETE("/conversations/:id", controllers.DeleteConversation)
+
+	}
+
+	// Maintenance endpoints require MAINTENANCE_TOKEN
+	maintenance := api.Group("/maintenance", requireMaintenanceToken())
+	{
+		maintenance.POST("/reconcile", controllers.Reconcile)
+		maintenance.POST("/reindex", controllers.StartReindex)
+		maintenance.GET("/reindex", controllers.GetReindexStatus)
 	}
 
 	return r
@@ -0,0 +1,296 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Chunking strategies
+const (
+	// ChunkBySection merges elements into token-bounded chunks within a section
+	ChunkBySection = "section"
+	// ChunkByElement keeps one chunk per parsed element
+	ChunkByElement = "element"
+)
+
+// Element types produced by the chunker
+const (
+	compositeElementType = "CompositeElement"
+	titleElementType     = "Title"
+)
+
+// ChunkingConfig controls how parsed elements are merged into chunks
+type ChunkingConfig struct {
+	Strategy      string
+	MaxTokens     int // upper bound of a chunk, in EstimateTokens units
+	OverlapTokens int // tail of a chunk repeated at the start of the next one
+}
+
+// LoadChunkingConfig reads CHUNK_STRATEGY (default section), CHUNK_MAX_TOKENS
+// (default 256, the input limit of the default embedding model) and
+// CHUNK_OVERLAP_TOKENS (default 32)
+func LoadChunkingConfig() ChunkingConfig {
+	cfg := ChunkingConfig{Strategy: ChunkBySection, MaxTokens: 256, OverlapTokens: 32}
+	if v := strings.ToLower(os.Getenv("CHUNK_STRATEGY")); v == ChunkByElement {
+		cfg.Strategy = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("CHUNK_MAX_TOKENS")); err == nil && v > 0 {
+		cfg.MaxTokens = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("CHUNK_OVERLAP_TOKENS")); err == nil && v >= 0 {
+		cfg.OverlapTokens = v
+	}
+	// Overlap must leave room for new text in every chunk
+	cfg.OverlapTokens = min(cfg.OverlapTokens, cfg.MaxTokens/2)
+	return cfg
+}
+
+// ChunkElements merges parsed elements into chunks of at most cfg.MaxTokens, counting
+// their titles and the heading path above them. Title elements start a new chunk and
+// set the heading path of the chunks after them; elements too long for one chunk are
+// split between words. Consecutive chunks of a section share cfg.OverlapTokens of
+// text. Chunks carry their heading path and page range in their metadata and have no
+// vector, as element vectors do not apply.
+func ChunkElements(elements []ParsedElement, cfg ChunkingConfig) []ParsedElement {
+	if cfg.Strategy == ChunkByElement {
+		return elements
+	}
+
+	b := &chunkBuilder{cfg: cfg, ids: make(map[string]int)}
+	for _, el := range elements {
+		text := strings.TrimSpace(el.Text)
+		if text == "" {
+			continue
+		}
+
+		// A "title" longer than half a chunk is body text the parser mislabeled
+		if isTitleElement(el) && EstimateTokens(text) <= cfg.MaxTokens/2 {
+			b.addTitle(el, text)
+			continue
+		}
+
+		// Text following a heading shares the chunk with it, so the first piece gets
+		// what the titles leave. Later chunks of the section carry its full path.
+		limit := b.budgetUnder(b.headings) - cfg.OverlapTokens
+		first := limit
+		if len(b.body) == 0 {
+			first = b.budget() - cfg.OverlapTokens - b.tokens
+		}
+		if tokens := EstimateTokens(text); tokens <= first || (len(b.body) > 0 && tokens <= limit) {
+			b.addBody(el, text, false)
+			continue
+		}
+		for i, piece := range splitByTokens(text, first, limit) {
+			b.addBody(el, piece, i > 0)
+		}
+	}
+	b.flush(false)
+
+	return b.chunks
+}
+
+// isTitleElement reports whether an element or chunk holds nothing but headings. Such
+// chunks stay searchable by text but are not embedded on their own.
+func isTitleElement(el ParsedElement) bool {
+	return strings.EqualFold(el.Type, titleElementType)
+}
+
+// chunkBuilder accumulates the elements of the chunk being built
+type chunkBuilder struct {
+	cfg    ChunkingConfig
+	chunks []ParsedElement
+	ids    map[string]int // chunks started by each element, to keep IDs unique
+
+	headings     []string // heading path of the current section
+	headingDepth []int
+
+	firstID    string
+	lastID     string
+	titles     []string
+	titleDepth int
+	body       []string
+	tokens     int
+	firstPage  int
+	lastPage   int
+	source     string
+}
+
+// addTitle closes the chunk before a heading and moves the heading path
+func (b *chunkBuilder) addTitle(el ParsedElement, text string) {
+	depth, hasDepth := metadataInt(el.Metadata, "category_depth")
+
+	// A heading without body text that is followed by a sibling or parent heading
+	// becomes a title-only chunk; nested headings stay together
+	if len(b.body) > 0 || (len(b.titles) > 0 && (!hasDepth || depth <= b.titleDepth)) {
+		b.flush(false)
+	}
+
+	// Without depths every heading replaces the path
+	if !hasDepth {
+		depth = 0
+	}
+	for len(b.headingDepth) > 0 && b.headingDepth[len(b.headingDepth)-1] >= depth {
+		b.headings = b.headings[:len(b.headings)-1]
+		b.headingDepth = b.headingDepth[:len(b.headingDepth)-1]
+	}
+	b.headings = append(b.headings, text)
+	b.headingDepth = append(b.headingDepth, depth)
+
+	b.start(el)
+	b.titles = append(b.titles, text)
+	b.titleDepth = depth
+	b.tokens += EstimateTokens(text)
+}
+
+// addBody appends text, closing the chunk first if it would grow past the limit.
+// Continued pieces of a split element join the previous text on the same line.
+func (b *chunkBuilder) addBody(el ParsedElement, text string, continued bool) {
+	tokens := EstimateTokens(text)
+	if (len(b.body) > 0 || len(b.titles) > 0) && b.tokens+tokens > b.budget() {
+		b.flush(true)
+		// The overlap carried over may be the start of the same element
+		continued = continued && b.firstID == el.ElementID
+	}
+
+	b.start(el)
+	if continued && len(b.body) > 0 {
+		b.body[len(b.body)-1] += " " + text
+	} else {
+		b.body = append(b.body, text)
+	}
+	b.tokens += tokens
+}
+
+// budget is the token limit of the current chunk: MaxTokens less the heading path
+// above its own titles, which is stored with the chunk. The path takes at most half
+// of MaxTokens so deeply nested sections keep room for text.
+func (b *chunkBuilder) budget() int {
+	return b.budgetUnder(b.headings[:len(b.headings)-len(b.titles)])
+}
+
+// budgetUnder is the token limit of a chunk below the heading path
+func (b *chunkBuilder) budgetUnder(path []string) int {
+	return b.cfg.MaxTokens - min(EstimateTokens(strings.Join(path, " ")), b.cfg.MaxTokens/2)
+}
+
+// start records where the chunk begins, and extends its page range
+func (b *chunkBuilder) start(el ParsedElement) {
+	page, _ := metadataInt(el.Metadata, "page")
+	b.lastID = el.ElementID
+	if b.firstID == "" {
+		b.firstID = el.ElementID
+		b.firstPage = page
+		if source, ok := el.Metadata["source"].(string); ok {
+			b.source = source
+		}
+	}
+	if page > 0 {
+		if b.firstPage == 0 {
+			b.firstPage = page
+		}
+		b.lastPage = max(b.lastPage, page)
+	}
+}
+
+// flush emits the current chunk. With carry, the next chunk starts with the
+// overlapping tail of its text; a chunk of titles only has none to carry.
+func (b *chunkBuilder) flush(carry bool) {
+	if len(b.titles) == 0 && len(b.body) == 0 {
+		return
+	}
+
+	chunk := ParsedElement{
+		ElementID: b.chunkID(),
+		Type:      compositeElementType,
+		Text:      strings.Join(append(append([]string{}, b.titles...), b.body...), "\n\n"),
+		Metadata: map[string]interface{}{
+			"page":         b.firstPage,
+			"page_start":   b.firstPage,
+			"page_end":     max(b.firstPage, b.lastPage),
+			"heading_path": append([]string{}, b.headings...),
+		},
+	}
+	if len(b.body) == 0 {
+		chunk.Type = titleElementType
+	}
+	if b.source != "" {
+		chunk.Metadata["source"] = b.source
+	}
+	b.chunks = append(b.chunks, chunk)
+
+	var tail string
+	if carry && len(b.body) > 0 {
+		tail = tailByTokens(b.body[len(b.body)-1], b.cfg.OverlapTokens)
+	}
+	lastID, lastPage, source := b.lastID, b.lastPage, b.source
+
+	b.firstID, b.titles, b.body, b.tokens = "", nil, nil, 0
+	b.firstPage, b.lastPage, b.source = 0, 0, ""
+
+	// The overlap belongs to the element the previous chunk ended with
+	if tail != "" {
+		b.firstID, b.firstPage, b.lastPage, b.source = lastID, lastPage, lastPage, source
+		b.body = []string{tail}
+		b.tokens = EstimateTokens(tail)
+	}
+}
+
+// chunkID names a chunk after the element it starts with; further chunks started by
+// the same element get a numeric suffix
+func (b *chunkBuilder) chunkID() string {
+	n := b.ids[b.firstID]
+	b.ids[b.firstID] = n + 1
+	if n == 0 {
+		return b.firstID
+	}
+	return fmt.Sprintf("%s-%d", b.firstID, n)
+}
+
+// splitByTokens breaks text between words into a first piece of at most first tokens
+// and further pieces of at most limit tokens. A piece holds at least one word.
+func splitByTokens(text string, first int, limit int) []string {
+	var pieces, words []string
+	tokens := 0
+	for _, word := range strings.Fields(text) {
+		t := wordTokens(word)
+		room := limit
+		if len(pieces) == 0 {
+			room = first
+		}
+		if len(words) > 0 && tokens+t > room {
+			pieces = append(pieces, strings.Join(words, " "))
+			words, tokens = nil, 0
+		}
+		words = append(words, word)
+		tokens += t
+	}
+	if len(words) > 0 {
+		pieces = append(pieces, strings.Join(words, " "))
+	}
+	return pieces
+}
+
+// tailByTokens returns the last words of text that fit in limit tokens
+func tailByTokens(text string, limit int) string {
+	words := strings.Fields(text)
+	tokens, start := 0, len(words)
+	for start > 0 && tokens+wordTokens(words[start-1]) <= limit {
+		start--
+		tokens += wordTokens(words[start])
+	}
+	return strings.Join(words[start:], " ")
+}
+
+// metadataInt reads a number from element metadata, which is float64 once decoded
+// from JSON
+func metadataInt(metadata map[string]interface{}, key string) (int, bool) {
+	switch v := metadata[key].(type) {
+	case float64:
+		return int(v), true
+	case int:
+		return v, true
+	}
+	return 0, false
+}
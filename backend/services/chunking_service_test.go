@@ -0,0 +1,197 @@
+package services
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// words returns n distinct one-token words starting at w<from>
+func words(from, n int) string {
+	list := make([]string, n)
+	for i := range list {
+		list[i] = fmt.Sprintf("w%d", from+i)
+	}
+	return strings.Join(list, " ")
+}
+
+func narrative(id string, text string, page int) ParsedElement {
+	return ParsedElement{ElementID: id, Type: "NarrativeText", Text: text, Metadata: map[string]interface{}{"page": float64(page)}}
+}
+
+func title(id string, text string, depth int) ParsedElement {
+	return ParsedElement{ElementID: id, Type: titleElementType, Text: text, Metadata: map[string]interface{}{"page": float64(1), "category_depth": float64(depth)}}
+}
+
+func TestChunkElementsMergesSmallElements(t *testing.T) {
+	elements := []ParsedElement{
+		narrative("e1", words(0, 5), 1),
+		narrative("e2", words(5, 5), 1),
+		narrative("e3", words(10, 5), 2),
+	}
+
+	chunks := ChunkElements(elements, ChunkingConfig{Strategy: ChunkBySection, MaxTokens: 20})
+
+	if len(chunks) != 1 {
+		t.Fatalf("got %d chunks, want 1", len(chunks))
+	}
+	chunk := chunks[0]
+	if want := words(0, 5) + "\n\n" + words(5, 5) + "\n\n" + words(10, 5); chunk.Text != want {
+		t.Errorf("text = %q, want %q", chunk.Text, want)
+	}
+	if chunk.ElementID != "e1" || chunk.Type != compositeElementType {
+		t.Errorf("chunk is %s %s, want e1 %s", chunk.ElementID, chunk.Type, compositeElementType)
+	}
+	if chunk.Metadata["page_start"] != 1 || chunk.Metadata["page_end"] != 2 {
+		t.Errorf("pages %v-%v, want 1-2", chunk.Metadata["page_start"], chunk.Metadata["page_end"])
+	}
+}
+
+func TestChunkElementsSplitsAtLimit(t *testing.T) {
+	tests := []struct {
+		name    string
+		words   int
+		overlap int
+		wantIDs []string
+	}{
+		{"exactly the limit", 10, 0, []string{"e1"}},
+		{"one word over the limit", 11, 0, []string{"e1", "e1-1"}},
+		{"split with overlap", 25, 2, []string{"e1", "e1-1", "e1-2", "e1-3"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := ChunkingConfig{Strategy: ChunkBySection, MaxTokens: 10, OverlapTokens: tt.overlap}
+			chunks := ChunkElements([]ParsedElement{narrative("e1", words(0, tt.words), 1)}, cfg)
+
+			var ids []string
+			for i, chunk := range chunks {
+				ids = append(ids, chunk.ElementID)
+				if tokens := EstimateTokens(chunk.Text); tokens > cfg.MaxTokens {
+					t.Errorf("chunk %d has %d tokens, limit is %d", i, tokens, cfg.MaxTokens)
+				}
+				if i > 0 && tt.overlap > 0 {
+					tail := tailByTokens(chunks[i-1].Text, tt.overlap)
+					if !strings.HasPrefix(chunk.Text, tail+" ") {
+						t.Errorf("chunk %d = %q does not start with the overlap %q", i, chunk.Text, tail)
+					}
+				}
+			}
+			if !reflect.DeepEqual(ids, tt.wantIDs) {
+				t.Errorf("chunk IDs = %v, want %v", ids, tt.wantIDs)
+			}
+		})
+	}
+}
+
+func TestChunkElementsHeadingPaths(t *testing.T) {
+	elements := []ParsedElement{
+		title("t1", "Intro", 0),
+		narrative("e1", words(0, 3), 1),
+		title("t2", "Details", 1),
+		narrative("e2", words(3, 3), 1),
+		title("t3", "Empty", 0),
+		title("t4", "Next", 0),
+		narrative("e3", words(6, 3), 1),
+	}
+
+	chunks := ChunkElements(elements, ChunkingConfig{Strategy: ChunkBySection, MaxTokens: 50})
+
+	want := []struct {
+		id   string
+		typ  string
+		text string
+		path []string
+	}{
+		{"t1", compositeElementType, "Intro\n\n" + words(0, 3), []string{"Intro"}},
+		{"t2", compositeElementType, "Details\n\n" + words(3, 3), []string{"Intro", "Details"}},
+		{"t3", titleElementType, "Empty", []string{"Empty"}},
+		{"t4", compositeElementType, "Next\n\n" + words(6, 3), []string{"Next"}},
+	}
+	if len(chunks) != len(want) {
+		t.Fatalf("got %d chunks, want %d", len(chunks), len(want))
+	}
+	for i, w := range want {
+		chunk := chunks[i]
+		if chunk.ElementID != w.id || chunk.Type != w.typ || chunk.Text != w.text {
+			t.Errorf("chunk %d = %s %s %q, want %s %s %q", i, chunk.ElementID, chunk.Type, chunk.Text, w.id, w.typ, w.text)
+		}
+		if path := chunk.Metadata["heading_path"]; !reflect.DeepEqual(path, w.path) {
+			t.Errorf("chunk %d heading path = %v, want %v", i, path, w.path)
+		}
+	}
+}
+
+func TestChunkElementsCountsTitlesAndPath(t *testing.T) {
+	cfg := ChunkingConfig{Strategy: ChunkBySection, MaxTokens: 10}
+	elements := []ParsedElement{
+		title("t1", "a b c", 0),
+		narrative("e1", words(0, 9), 1),
+	}
+
+	chunks := ChunkElements(elements, cfg)
+
+	// The title leaves 7 tokens for the text after it; the rest of the element goes
+	// to a chunk whose budget is reduced by the 3-token heading path
+	want := []string{"a b c\n\n" + words(0, 7), words(7, 2)}
+	if len(chunks) != len(want) {
+		t.Fatalf("got %d chunks, want %d", len(chunks), len(want))
+	}
+	for i, text := range want {
+		if chunks[i].Text != text {
+			t.Errorf("chunk %d = %q, want %q", i, chunks[i].Text, text)
+		}
+	}
+
+	// A continuation chunk leaves room for the heading path stored with it
+	long := ChunkElements([]ParsedElement{title("t1", "a b c", 0), narrative("e1", words(0, 30), 1)}, cfg)
+	for i, chunk := range long[1:] {
+		if tokens := EstimateTokens(chunk.Text) + 3; tokens > cfg.MaxTokens {
+			t.Errorf("chunk %d with its heading path has %d tokens, limit is %d", i+1, tokens, cfg.MaxTokens)
+		}
+	}
+}
+
+func TestChunkElementsOversizedTitles(t *testing.T) {
+	cfg := ChunkingConfig{Strategy: ChunkBySection, MaxTokens: 10, OverlapTokens: 2}
+
+	tests := []struct {
+		name     string
+		elements []ParsedElement
+	}{
+		{"paragraph tagged as title", []ParsedElement{title("t1", words(0, 300), 0)}},
+		{"long title before body", []ParsedElement{title("t1", words(0, 30), 0), narrative("e1", words(30, 20), 1)}},
+		{"nested titles fill the chunk", []ParsedElement{
+			title("t1", words(0, 5), 0),
+			title("t2", words(5, 5), 1),
+			narrative("e1", words(10, 20), 1),
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunks := ChunkElements(tt.elements, cfg)
+
+			if len(chunks) == 0 {
+				t.Fatal("got no chunks")
+			}
+			seen := map[string]bool{}
+			for i, chunk := range chunks {
+				if tokens := EstimateTokens(chunk.Text); tokens > cfg.MaxTokens {
+					t.Errorf("chunk %d has %d tokens, limit is %d", i, tokens, cfg.MaxTokens)
+				}
+				for _, word := range strings.Fields(chunk.Text) {
+					seen[word] = true
+				}
+			}
+			for _, el := range tt.elements {
+				for _, word := range strings.Fields(el.Text) {
+					if !seen[word] {
+						t.Fatalf("word %s of %s is missing from the chunks", word, el.ElementID)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package services
+
+import (
+	"bpt-knowledge-center/backend/models"
+	"bpt-knowledge-center/backend/repositories"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// snippetLength is the maximum length of the text excerpt attached to a source
+const snippetLength = 240
+
+// citationPattern matches inline citations such as [1], [2, 3] or [4,5]
+var citationPattern = regexp.MustCompile(`\[\s*\d+(?:\s*,\s*\d+)*\s*\]`)
+
+// maxCitationNumber is the largest number read as a citation; a bracketed group with
+// a larger number, such as a year, is left as written
+const maxCitationNumber = 99
+
+// removedCitation marks a stripped citation together with the whitespace before it
+const removedCitation = "\x00"
+
+var removedCitationPattern = regexp.MustCompile(`[ \t]*\x00`)
+
+// BuildSources numbers the matches as citation sources 1..n, in prompt order, and
+// attaches the metadata of the document each chunk belongs to. The version and file
+// link are those of the cited chunk; the link is the stable DownloadPath, as sources
+// are stored with the conversation and presigned URLs expire.
+func BuildSources(matches []ChunkMatch) []models.Source {
+	docs := make(map[string]*models.Document)
+	sources := make([]models.Source, 0, len(matches))
+
+	for i, match := range matches {
+		source := models.Source{
+			Index:      i + 1,
+			DocumentID: match.DocumentID,
+			Filename:   match.Source,
+			Page:       match.Page,
+			ChunkID:    match.ChunkID,
+			Version:    match.Version,
+			Snippet:    snippet(match.Text, snippetLength),
+		}
+
+		if match.DocumentID != "" {
+			doc, seen := docs[match.DocumentID]
+			if !seen {
+				var err error
+				if doc, err = repositories.GetDocumentByID(match.DocumentID); err != nil {
+					log.Printf("Warning: Failed to load document %s for citation: %v", match.DocumentID, err)
+				}
+				docs[match.DocumentID] = doc
+			}
+			if doc != nil {
+				source.DisplayName = doc.DisplayName
+				// Chunks written before versioning belong to the current version
+				if source.Version == 0 {
+					source.Version = doc.Version
+				}
+				source.FileURL = DownloadPath(doc.ID, source.Version)
+				if source.Filename == "" {
+					source.Filename = doc.Filename
+				}
+			}
+		}
+		if source.DisplayName == "" {
+			source.DisplayName = source.Filename
+		}
+
+		sources = append(sources, source)
+	}
+
+	return sources
+}
+
+// PresignSources returns a copy of the sources whose file links are presigned URLs of
+// the cited versions, for responses. Sources that cannot be presigned keep their
+// download path.
+func PresignSources(sources []models.Source) []models.Source {
+	presigned := make([]models.Source, len(sources))
+	copy(presigned, sources)
+
+	docs := make(map[string]*models.Document)
+	for i, source := range presigned {
+		if source.DocumentID == "" || source.Version == 0 {
+			continue
+		}
+		doc, seen := docs[source.DocumentID]
+		if !seen {
+			doc, _ = repositories.GetDocumentByID(source.DocumentID)
+			docs[source.DocumentID] = doc
+		}
+		if doc == nil {
+			continue
+		}
+		key, err := ResolveObjectKey(doc, source.Version)
+		if err != nil {
+			continue
+		}
+		if url, _, err := PresignDownload(key, doc.Filename); err == nil {
+			presigned[i].FileURL = url
+		}
+	}
+	return presigned
+}
+
+// ApplyCitations removes citation numbers that don't match any source and marks the
+// sources that the answer cites. Bracketed groups with a number above maxCitationNumber,
+// such as "[2023]", are not citations and are left as written.
+func ApplyCitations(answer string, sources []models.Source) string {
+	byIndex := make(map[int]int, len(sources))
+	for i, source := range sources {
+		byIndex[source.Index] = i
+	}
+
+	// Invalid citations are replaced by a marker first so the space before them can go too
+	answer = citationPattern.ReplaceAllStringFunc(answer, func(citation string) string {
+		var numbers []int
+		for _, part := range strings.Split(strings.Trim(citation, "[] "), ",") {
+			n, err := strconv.Atoi(strings.TrimSpace(part))
+			if err != nil || n > maxCitationNumber {
+				return citation
+			}
+			numbers = append(numbers, n)
+		}
+
+		var valid []string
+		for _, n := range numbers {
+			if i, ok := byIndex[n]; ok {
+				sources[i].Cited = true
+				valid = append(valid, strconv.Itoa(n))
+			}
+		}
+
+		if len(valid) == 0 {
+			return removedCitation
+		}
+		return "[" + strings.Join(valid, ", ") + "]"
+	})
+
+	return removedCitationPattern.ReplaceAllString(answer, "")
+}
+
+// snippet shortens text to at most max bytes, cutting at a word boundary, or at a
+// character boundary if the first max bytes hold no space
+func snippet(text string, max int) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if len(text) <= max {
+		return text
+	}
+
+	cut := strings.LastIndex(text[:max], " ")
+	if cut <= 0 {
+		cut = max
+		for cut > 0 && !utf8.RuneStart(text[cut]) {
+			cut--
+		}
+	}
+	return strings.TrimRight(text[:cut], " ,.;:") + "..."
+}
@@ -0,0 +1,63 @@
+package services
+
+import (
+	"bpt-knowledge-center/backend/models"
+	"reflect"
+	"testing"
+)
+
+func TestApplyCitations(t *testing.T) {
+	tests := []struct {
+		name      string
+		answer    string
+		want      string
+		wantCited []bool
+	}{
+		{"valid citations", "BPT ships in May [1] and June [2, 3].", "BPT ships in May [1] and June [2, 3].", []bool{true, true, true}},
+		{"unknown source stripped with its space", "BPT ships in May [7].", "BPT ships in May.", []bool{false, false, false}},
+		{"group keeps only known sources", "See [2,7, 3].", "See [2, 3].", []bool{false, true, true}},
+		{"zero is not a source", "See [0] and [1].", "See and [1].", []bool{true, false, false}},
+		{"year left as written", "Released in [2023] [1].", "Released in [2023] [1].", []bool{true, false, false}},
+		{"group with a year left as written", "Between [1, 2024].", "Between [1, 2024].", []bool{false, false, false}},
+		{"no citations", "Nothing cited.", "Nothing cited.", []bool{false, false, false}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sources := []models.Source{{Index: 1}, {Index: 2}, {Index: 3}}
+
+			if got := ApplyCitations(tt.answer, sources); got != tt.want {
+				t.Errorf("answer = %q, want %q", got, tt.want)
+			}
+			var cited []bool
+			for _, source := range sources {
+				cited = append(cited, source.Cited)
+			}
+			if !reflect.DeepEqual(cited, tt.wantCited) {
+				t.Errorf("cited = %v, want %v", cited, tt.wantCited)
+			}
+		})
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		max  int
+		want string
+	}{
+		{"short text kept", "a  short\n text", 20, "a short text"},
+		{"cut at a word boundary", "alpha beta, gamma", 13, "alpha beta..."},
+		{"cut inside a long word", "abcdefghij", 4, "abcd..."},
+		{"cut on a rune boundary", "ééééé", 5, "éé..."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := snippet(tt.text, tt.max); got != tt.want {
+				t.Errorf("snippet = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
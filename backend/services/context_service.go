@@ -0,0 +1,244 @@
+package services
+
+import (
+	"bpt-knowledge-center/backend/models"
+	"bpt-knowledge-center/backend/repositories"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxNeighbors bounds how many chunks on each side of a hit a request can pull in
+const maxNeighbors = 5
+
+// defaultNeighbors reads CONTEXT_NEIGHBORS (default 0, no expansion)
+func defaultNeighbors() int {
+	if v, err := strconv.Atoi(os.Getenv("CONTEXT_NEIGHBORS")); err == nil && v >= 0 {
+		return v
+	}
+	return 0
+}
+
+// defaultContextTokens reads CONTEXT_MAX_TOKENS (default 3000), the budget of
+// expanded matches in EstimateTokens units
+func defaultContextTokens() int {
+	if v, err := strconv.Atoi(os.Getenv("CONTEXT_MAX_TOKENS")); err == nil && v > 0 {
+		return v
+	}
+	return 3000
+}
+
+// chunkWindow is a run of consecutive chunks of one document version around one or
+// more hits
+type chunkWindow struct {
+	documentID string
+	version    int
+	from, to   int
+	best       ChunkMatch // highest-ranked hit in the window
+	rank       int
+	hits       map[int]bool // sequence numbers of the hits
+	chunks     []models.Chunk
+}
+
+// ExpandNeighbors replaces each match by a window of up to k chunks before and after
+// it in the same document version. Overlapping or adjacent windows are merged into
+// one match at the rank of their best hit. Windows are then added in rank order until
+// budget tokens are used; a window that does not fit loses neighbors from its ends,
+// and is dropped if its hits alone do not fit. The top match is always kept.
+func ExpandNeighbors(matches []ChunkMatch, k int, budget int) []ChunkMatch {
+	if k <= 0 || len(matches) == 0 {
+		return matches
+	}
+	k = min(k, maxNeighbors)
+
+	var windows []*chunkWindow
+	for rank, match := range matches {
+		chunk, err := repositories.GetChunkByID(match.ChunkKey)
+		if err != nil {
+			log.Printf("Warning: Failed to load chunk %s for expansion: %v", match.ChunkKey, err)
+		}
+		window := &chunkWindow{best: match, rank: rank, hits: map[int]bool{}}
+		if chunk != nil {
+			window.documentID = chunk.DocumentID
+			window.version = chunk.Version
+			window.from = max(chunk.Seq-k, 0)
+			window.to = chunk.Seq + k
+			window.hits[chunk.Seq] = true
+		}
+		windows = append(windows, window)
+	}
+
+	windows = mergeWindows(windows)
+
+	expanded := make([]ChunkMatch, 0, len(windows))
+	used := 0
+	for _, window := range windows {
+		if window.documentID != "" {
+			chunks, err := repositories.GetChunkRange(window.documentID, window.version, window.from, window.to)
+			if err != nil {
+				log.Printf("Warning: Failed to load neighbors of %s: %v", window.best.ChunkKey, err)
+			}
+			window.chunks = chunks
+		}
+
+		match, tokens, ok := window.fit(budget - used)
+		if !ok && len(expanded) > 0 {
+			continue
+		}
+		expanded = append(expanded, match)
+		used += tokens
+	}
+
+	return expanded
+}
+
+// mergeWindows joins windows of the same document version that overlap or touch,
+// and orders the result by the rank of each window's best hit
+func mergeWindows(windows []*chunkWindow) []*chunkWindow {
+	var merged []*chunkWindow
+	for _, window := range windows {
+		if window.documentID == "" {
+			merged = append(merged, window)
+			continue
+		}
+
+		// Absorbing a window can make it reach others, so repeat until stable
+		for {
+			joined := false
+			for i, other := range merged {
+				if other.documentID != window.documentID || other.version != window.version ||
+					window.from > other.to+1 || other.from > window.to+1 {
+					continue
+				}
+				window = joinWindows(other, window)
+				merged = append(merged[:i], merged[i+1:]...)
+				joined = true
+				break
+			}
+			if !joined {
+				break
+			}
+		}
+		merged = append(merged, window)
+	}
+
+	sort.SliceStable(merged, func(i, j int) bool {
+		return merged[i].rank < merged[j].rank
+	})
+	return merged
+}
+
+func joinWindows(a, b *chunkWindow) *chunkWindow {
+	joined := *a
+	if b.rank < a.rank {
+		joined.best, joined.rank = b.best, b.rank
+	}
+	joined.from = min(a.from, b.from)
+	joined.to = max(a.to, b.to)
+	joined.hits = make(map[int]bool, len(a.hits)+len(b.hits))
+	for seq := range a.hits {
+		joined.hits[seq] = true
+	}
+	for seq := range b.hits {
+		joined.hits[seq] = true
+	}
+	return &joined
+}
+
+// fit renders the window within the token limit, dropping neighbors from whichever
+// end is farther from a hit. ok is false if the hits alone exceed the limit.
+func (w *chunkWindow) fit(limit int) (ChunkMatch, int, bool) {
+	match := w.best
+	if len(w.chunks) == 0 {
+		tokens := EstimateTokens(match.Text)
+		return match, tokens, tokens <= limit
+	}
+
+	lo, hi := 0, len(w.chunks)-1
+	text := joinChunkTexts(w.chunks[lo : hi+1])
+	tokens := EstimateTokens(text)
+	for tokens > limit {
+		loHit, hiHit := w.hits[w.chunks[lo].Seq], w.hits[w.chunks[hi].Seq]
+		switch {
+		case loHit && hiHit:
+			// Only hits are left at the ends; keep the window whole if it is the top match
+			return w.render(lo, hi, text), tokens, false
+		case !loHit && (hiHit || w.distanceToHit(lo) >= w.distanceToHit(hi)):
+			lo++
+		default:
+			hi--
+		}
+		text = joinChunkTexts(w.chunks[lo : hi+1])
+		tokens = EstimateTokens(text)
+	}
+
+	return w.render(lo, hi, text), tokens, true
+}
+
+// render builds the match for chunks[lo..hi] with the metadata of the best hit
+func (w *chunkWindow) render(lo, hi int, text string) ChunkMatch {
+	match := w.best
+	match.Text = text
+	if lo < hi {
+		match.NeighborKeys = make([]string, 0, hi-lo+1)
+		for _, chunk := range w.chunks[lo : hi+1] {
+			match.NeighborKeys = append(match.NeighborKeys, chunk.ID)
+		}
+	}
+	return match
+}
+
+// distanceToHit counts the chunks between chunks[i] and the nearest hit
+func (w *chunkWindow) distanceToHit(i int) int {
+	best := len(w.chunks)
+	for j, chunk := range w.chunks {
+		if w.hits[chunk.Seq] {
+			best = min(best, max(i-j, j-i))
+		}
+	}
+	return best
+}
+
+// joinChunkTexts concatenates consecutive chunks, dropping the text a chunk repeats
+// from the end of the previous one
+func joinChunkTexts(chunks []models.Chunk) string {
+	var sb strings.Builder
+	prev := ""
+	for i, chunk := range chunks {
+		text := chunk.Text
+		if i > 0 {
+			text = strings.TrimSpace(strings.TrimPrefix(text, sharedOverlap(prev, text)))
+			if text == "" {
+				continue
+			}
+			sb.WriteString("\n\n")
+		}
+		sb.WriteString(text)
+		prev = chunk.Text
+	}
+	return sb.String()
+}
+
+// sharedOverlap returns the start of b that repeats the last words of a, as left by
+// chunking with overlap. At least minOverlapWords must match, so a common word at a
+// chunk boundary is not taken for overlap.
+func sharedOverlap(a, b string) string {
+	const minOverlapWords = 3
+
+	tail := " " + strings.Join(strings.Fields(a), " ")
+	words := strings.Fields(b)
+	for n := min(len(words), 128); n >= minOverlapWords; n-- {
+		if !strings.HasSuffix(tail, " "+strings.Join(words[:n], " ")) {
+			continue
+		}
+		// Map the matched words back onto b, which may separate them differently
+		end := 0
+		for _, word := range words[:n] {
+			end += strings.Index(b[end:], word) + len(word)
+		}
+		return b[:end]
+	}
+	return ""
+}
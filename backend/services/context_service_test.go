@@ -0,0 +1,154 @@
+package services
+
+import (
+	"bpt-knowledge-center/backend/models"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestSharedOverlap(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want string
+	}{
+		{"overlap", "a b c d e", "c d e f g", "c d e"},
+		{"too short to be overlap", "a b c d", "c d e", ""},
+		{"no overlap", "a b c", "d e f", ""},
+		{"whitespace differs", "a b c d", "b\nc  d e", "b\nc  d"},
+		{"whole chunk repeated", "a b c d", "b c d", "b c d"},
+		{"partial word is not overlap", "xa b c", "a b c d", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sharedOverlap(tt.a, tt.b); got != tt.want {
+				t.Errorf("sharedOverlap = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJoinChunkTexts(t *testing.T) {
+	tests := []struct {
+		name  string
+		texts []string
+		want  string
+	}{
+		{"single chunk", []string{"a b c"}, "a b c"},
+		{"overlap dropped", []string{"w0 w1 w2 w3", "w1 w2 w3 w4"}, "w0 w1 w2 w3\n\nw4"},
+		{"no overlap", []string{"a b", "c d"}, "a b\n\nc d"},
+		{"repeated chunk skipped", []string{"a b c d e", "c d e", "c d e f"}, "a b c d e\n\nf"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunks := make([]models.Chunk, len(tt.texts))
+			for i, text := range tt.texts {
+				chunks[i] = models.Chunk{Text: text}
+			}
+			if got := joinChunkTexts(chunks); got != tt.want {
+				t.Errorf("joined = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func window(doc string, version, from, to, rank int) *chunkWindow {
+	return &chunkWindow{documentID: doc, version: version, from: from, to: to, rank: rank,
+		best: ChunkMatch{ChunkKey: fmt.Sprintf("hit%d", rank)}, hits: map[int]bool{(from + to) / 2: true}}
+}
+
+func TestMergeWindows(t *testing.T) {
+	type span struct{ from, to, rank int }
+
+	tests := []struct {
+		name    string
+		windows []*chunkWindow
+		want    []span
+	}{
+		{"other documents stay apart", []*chunkWindow{window("d1", 1, 0, 2, 0), window("d2", 1, 0, 2, 1)}, []span{{0, 2, 0}, {0, 2, 1}}},
+		{"other versions stay apart", []*chunkWindow{window("d1", 1, 0, 2, 0), window("d1", 2, 0, 2, 1)}, []span{{0, 2, 0}, {0, 2, 1}}},
+		{"overlapping windows merge at the best rank", []*chunkWindow{window("d1", 1, 4, 8, 0), window("d1", 1, 2, 6, 1)}, []span{{2, 8, 0}}},
+		{"adjacent windows merge", []*chunkWindow{window("d1", 1, 0, 2, 1), window("d1", 1, 3, 5, 0)}, []span{{0, 5, 0}}},
+		{"gap keeps windows apart", []*chunkWindow{window("d1", 1, 0, 2, 0), window("d1", 1, 4, 6, 1)}, []span{{0, 2, 0}, {4, 6, 1}}},
+		{"window bridging two merges all", []*chunkWindow{window("d1", 1, 0, 2, 2), window("d1", 1, 6, 8, 0), window("d1", 1, 3, 5, 1)}, []span{{0, 8, 0}}},
+		{"unloaded chunks never merge", []*chunkWindow{window("", 0, 0, 0, 0), window("", 0, 0, 0, 1)}, []span{{0, 0, 0}, {0, 0, 1}}},
+		{"ordered by rank", []*chunkWindow{window("d2", 1, 0, 2, 1), window("d1", 1, 0, 2, 0)}, []span{{0, 2, 0}, {0, 2, 1}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []span
+			for _, merged := range mergeWindows(tt.windows) {
+				got = append(got, span{merged.from, merged.to, merged.rank})
+				if merged.best.ChunkKey != fmt.Sprintf("hit%d", merged.rank) {
+					t.Errorf("window at rank %d has best hit %s", merged.rank, merged.best.ChunkKey)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("windows = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChunkWindowFit(t *testing.T) {
+	// Five chunks of 5 tokens each with distinct words
+	chunks := make([]models.Chunk, 5)
+	for seq := range chunks {
+		chunks[seq] = models.Chunk{ID: fmt.Sprintf("c%d", seq), Seq: seq, Text: words(seq*5, 5)}
+	}
+
+	tests := []struct {
+		name       string
+		hits       []int
+		chunks     []models.Chunk
+		limit      int
+		wantKeys   []string
+		wantText   string
+		wantTokens int
+		wantOK     bool
+	}{
+		{"whole window fits", []int{1}, chunks, 25, []string{"c0", "c1", "c2", "c3", "c4"}, words(0, 5) + "\n\n" + words(5, 5) + "\n\n" + words(10, 5) + "\n\n" + words(15, 5) + "\n\n" + words(20, 5), 25, true},
+		{"farther end dropped first", []int{1}, chunks, 15, []string{"c0", "c1", "c2"}, words(0, 5) + "\n\n" + words(5, 5) + "\n\n" + words(10, 5), 15, true},
+		{"only the hit fits", []int{1}, chunks, 5, nil, words(5, 5), 5, true},
+		{"hit alone too large", []int{1}, chunks, 4, nil, words(5, 5), 5, false},
+		{"hits at both ends are kept whole", []int{0, 4}, chunks, 10, []string{"c0", "c1", "c2", "c3", "c4"}, words(0, 5) + "\n\n" + words(5, 5) + "\n\n" + words(10, 5) + "\n\n" + words(15, 5) + "\n\n" + words(20, 5), 25, false},
+		{"chunks not loaded", []int{1}, nil, 3, nil, "the hit", 2, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &chunkWindow{best: ChunkMatch{ChunkKey: "hit", Text: "the hit"}, hits: map[int]bool{}, chunks: tt.chunks}
+			for _, seq := range tt.hits {
+				w.hits[seq] = true
+			}
+
+			match, tokens, ok := w.fit(tt.limit)
+
+			if ok != tt.wantOK || tokens != tt.wantTokens {
+				t.Errorf("fit = %d tokens, ok %v; want %d, %v", tokens, ok, tt.wantTokens, tt.wantOK)
+			}
+			if match.Text != tt.wantText {
+				t.Errorf("text = %q, want %q", match.Text, tt.wantText)
+			}
+			if !reflect.DeepEqual(match.NeighborKeys, tt.wantKeys) {
+				t.Errorf("neighbor keys = %v, want %v", match.NeighborKeys, tt.wantKeys)
+			}
+			if match.ChunkKey != "hit" {
+				t.Errorf("match key = %s, want the best hit", match.ChunkKey)
+			}
+		})
+	}
+}
+
+func TestExpandNeighborsDisabled(t *testing.T) {
+	matches := []ChunkMatch{{ChunkKey: "a"}, {ChunkKey: "b"}}
+	for _, k := range []int{0, -1} {
+		if got := ExpandNeighbors(matches, k, 100); !reflect.DeepEqual(got, matches) {
+			t.Errorf("k = %d changed the matches to %v", k, got)
+		}
+	}
+}
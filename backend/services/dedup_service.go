@@ -0,0 +1,53 @@
+package services
+
+import (
+	"bpt-knowledge-center/backend/repositories"
+	"log"
+	"os"
+	"strings"
+)
+
+// Duplicate upload policies
+const (
+	DuplicateReject = "reject" // refuse the upload and point to the existing document
+	DuplicateLink   = "link"   // record the filename as an alias of the existing document
+)
+
+// DuplicatePolicy reads DUPLICATE_POLICY (reject or link, default reject)
+func DuplicatePolicy() string {
+	switch policy := strings.ToLower(os.Getenv("DUPLICATE_POLICY")); policy {
+	case DuplicateLink:
+		return policy
+	case "", DuplicateReject:
+	default:
+		log.Printf("Warning: Unknown DUPLICATE_POLICY %q, rejecting duplicates", policy)
+	}
+	return DuplicateReject
+}
+
+// FindDuplicate returns the ID of the document that already has this content, either
+// saved or still being ingested, or "" when the content is new
+func FindDuplicate(hash string) (string, error) {
+	doc, err := repositories.GetDocumentByHash(hash)
+	if err != nil {
+		return "", err
+	}
+	if doc != nil {
+		return doc.ID, nil
+	}
+
+	job, err := repositories.GetPendingJobByHash(hash)
+	if err != nil {
+		return "", err
+	}
+	if job != nil {
+		return job.DocumentID, nil
+	}
+
+	return "", nil
+}
+
+// LinkDuplicate records an identical upload as an alias of the existing document
+func LinkDuplicate(documentID string, filename string) error {
+	return repositories.AddDocumentAlias(documentID, filename)
+}
@@ -0,0 +1,265 @@
+package services
+
+import (
+	"bpt-knowledge-center/backend/models"
+	"bpt-knowledge-center/backend/repositories"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Kinds of change between two versions
+const (
+	ChangeAdded    = "added"
+	ChangeRemoved  = "removed"
+	ChangeModified = "modified"
+)
+
+// SectionChange is one added, removed or modified chunk
+type SectionChange struct {
+	Kind        string  `json:"kind"`
+	ElementType string  `json:"element_type"`
+	FromPage    int     `json:"from_page,omitempty"`
+	ToPage      int     `json:"to_page,omitempty"`
+	FromText    string  `json:"from_text,omitempty"`
+	ToText      string  `json:"to_text,omitempty"`
+	Similarity  float64 `json:"similarity,omitempty"` // text similarity of modified sections
+	position    float64 // order of the change in the newer version
+}
+
+// VersionDiff lists what changed between two versions of a document
+type VersionDiff struct {
+	DocumentID string          `json:"document_id"`
+	From       int             `json:"from"`
+	To         int             `json:"to"`
+	Added      int             `json:"added"`
+	Removed    int             `json:"removed"`
+	Modified   int             `json:"modified"`
+	Unchanged  int             `json:"unchanged"`
+	Changes    []SectionChange `json:"changes"`
+	Summary    string          `json:"summary,omitempty"`
+}
+
+// diffSimilarityThreshold reads DIFF_SIMILARITY_THRESHOLD (default 0.5), the text
+// similarity above which two chunks count as the same section, modified
+func diffSimilarityThreshold() float64 {
+	if v, err := strconv.ParseFloat(os.Getenv("DIFF_SIMILARITY_THRESHOLD"), 64); err == nil && v > 0 && v <= 1 {
+		return v
+	}
+	return 0.5
+}
+
+// semanticMatchThreshold is the vector similarity at which chunks are paired even
+// when rewording left little text in common
+const semanticMatchThreshold = 0.9
+
+// DiffVersions compares the chunks of two versions of a document
+func DiffVersions(documentID string, from int, to int) (*VersionDiff, error) {
+	doc, err := repositories.GetDocumentByID(documentID)
+	if err != nil {
+		return nil, err
+	}
+
+	oldChunks, err := versionChunks(doc, from)
+	if err != nil {
+		return nil, err
+	}
+	newChunks, err := versionChunks(doc, to)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := &VersionDiff{DocumentID: documentID, From: from, To: to}
+	alignChunks(diff, oldChunks, newChunks)
+	return diff, nil
+}
+
+// alignChunks pairs the chunks of two versions and records the changes in diff.
+// Identical chunks are paired first; the rest are paired with the most similar
+// unpaired chunk of the same element type. Whatever stays unpaired was removed from
+// the old version or added in the new one.
+func alignChunks(diff *VersionDiff, oldChunks []models.Chunk, newChunks []models.Chunk) {
+	diff.Changes = []SectionChange{}
+
+	// partner[i] is the index in newChunks paired with oldChunks[i], or -1
+	partner := make([]int, len(oldChunks))
+	paired := make([]bool, len(newChunks))
+	for i := range partner {
+		partner[i] = -1
+	}
+
+	// 1. Unchanged chunks, matched on normalized text in document order
+	byText := make(map[string][]int)
+	for j, chunk := range newChunks {
+		key := normalizeText(chunk.Text)
+		byText[key] = append(byText[key], j)
+	}
+	for i, chunk := range oldChunks {
+		key := normalizeText(chunk.Text)
+		if candidates := byText[key]; len(candidates) > 0 {
+			partner[i] = candidates[0]
+			paired[candidates[0]] = true
+			byText[key] = candidates[1:]
+			diff.Unchanged++
+		}
+	}
+
+	// 2. Modified chunks, matched on similarity within the same element type. Vectors
+	// are only compared when the same model produced them.
+	threshold := diffSimilarityThreshold()
+	newTerms := make([]map[string]bool, len(newChunks))
+	for j, newChunk := range newChunks {
+		if !paired[j] {
+			newTerms[j] = termSet(newChunk.Text)
+		}
+	}
+	for i, oldChunk := range oldChunks {
+		if partner[i] >= 0 {
+			continue
+		}
+
+		best, bestScore := -1, 0.0
+		oldTerms := termSet(oldChunk.Text)
+		for j, newChunk := range newChunks {
+			if paired[j] || newChunk.ElementType != oldChunk.ElementType {
+				continue
+			}
+			score := jaccard(oldTerms, newTerms[j])
+			if score < threshold && (newChunk.EmbeddingModel != oldChunk.EmbeddingModel ||
+				cosineSimilarity(oldChunk.Vector, newChunk.Vector) < semanticMatchThreshold) {
+				continue
+			}
+			if best < 0 || score > bestScore {
+				best, bestScore = j, score
+			}
+		}
+		if best < 0 {
+			continue
+		}
+
+		partner[i] = best
+		paired[best] = true
+		diff.Modified++
+		diff.Changes = append(diff.Changes, SectionChange{
+			Kind:        ChangeModified,
+			ElementType: oldChunk.ElementType,
+			FromPage:    oldChunk.Page,
+			ToPage:      newChunks[best].Page,
+			FromText:    oldChunk.Text,
+			ToText:      newChunks[best].Text,
+			Similarity:  math.Round(bestScore*1000) / 1000,
+			position:    float64(best),
+		})
+	}
+
+	// 3. Removed chunks are placed after the new position of the chunk before them
+	previous := -1.0
+	for i, oldChunk := range oldChunks {
+		if partner[i] >= 0 {
+			previous = float64(partner[i])
+			continue
+		}
+		diff.Removed++
+		diff.Changes = append(diff.Changes, SectionChange{
+			Kind:        ChangeRemoved,
+			ElementType: oldChunk.ElementType,
+			FromPage:    oldChunk.Page,
+			FromText:    oldChunk.Text,
+			position:    previous + 0.5,
+		})
+	}
+
+	// 4. Added chunks
+	for j, newChunk := range newChunks {
+		if paired[j] {
+			continue
+		}
+		diff.Added++
+		diff.Changes = append(diff.Changes, SectionChange{
+			Kind:        ChangeAdded,
+			ElementType: newChunk.ElementType,
+			ToPage:      newChunk.Page,
+			ToText:      newChunk.Text,
+			position:    float64(j),
+		})
+	}
+
+	sort.SliceStable(diff.Changes, func(a, b int) bool {
+		return diff.Changes[a].position < diff.Changes[b].position
+	})
+}
+
+// versionChunks loads the chunks of a version. Chunks saved before versioned chunks
+// only exist for the current version.
+func versionChunks(doc *models.Document, version int) ([]models.Chunk, error) {
+	if version < 1 || version > doc.Version {
+		return nil, ErrVersionNotFound
+	}
+
+	chunks, err := repositories.GetChunksByVersion(doc.ID, version)
+	if err != nil {
+		return nil, err
+	}
+	if len(chunks) == 0 && version == doc.Version {
+		chunks, err = repositories.GetChunksByDocument(doc.ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(chunks) == 0 {
+		return nil, ErrVersionNotRestorable
+	}
+
+	return chunks, nil
+}
+
+// normalizeText lowercases text and collapses whitespace, so layout-only changes
+// do not count as modifications
+func normalizeText(text string) string {
+	return strings.Join(strings.Fields(strings.ToLower(text)), " ")
+}
+
+func termSet(text string) map[string]bool {
+	terms := make(map[string]bool)
+	for _, term := range strings.FieldsFunc(strings.ToLower(text), isTermSeparator) {
+		terms[term] = true
+	}
+	return terms
+}
+
+func isTermSeparator(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+}
+
+func jaccard(a, b map[string]bool) float64 {
+	if len(a) == 0 && len(b) == 0 {
+		return 1
+	}
+	shared := 0
+	for term := range a {
+		if b[term] {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(a)+len(b)-shared)
+}
+
+// cosineSimilarity of two embeddings, 0 when either is missing
+func cosineSimilarity(a, b []float32) float64 {
+	if len(a) == 0 || len(a) != len(b) {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
@@ -0,0 +1,128 @@
+package services
+
+import (
+	"bpt-knowledge-center/backend/models"
+	"reflect"
+	"testing"
+)
+
+func chunk(text string, elementType string) models.Chunk {
+	return models.Chunk{Text: text, ElementType: elementType, Page: 1}
+}
+
+func embeddedChunk(text string, model string, vec ...float32) models.Chunk {
+	c := chunk(text, "NarrativeText")
+	c.EmbeddingModel = model
+	c.Vector = vec
+	return c
+}
+
+func TestAlignChunks(t *testing.T) {
+	a := chunk("Press the red button to stop.", "NarrativeText")
+	b := chunk("Clean the filter every week.", "NarrativeText")
+	c := chunk("Replace the seals yearly.", "NarrativeText")
+	d := chunk("Order spare parts from the dealer.", "NarrativeText")
+
+	tests := []struct {
+		name          string
+		oldChunks     []models.Chunk
+		newChunks     []models.Chunk
+		wantUnchanged int
+		wantKinds     []string
+		wantTexts     []string // text of each change, the newer one when modified
+	}{
+		{
+			name:          "identical",
+			oldChunks:     []models.Chunk{a, b},
+			newChunks:     []models.Chunk{a, b},
+			wantUnchanged: 2,
+		},
+		{
+			name:          "case and whitespace only",
+			oldChunks:     []models.Chunk{a},
+			newChunks:     []models.Chunk{chunk("PRESS the red\nbutton  to stop.", "NarrativeText")},
+			wantUnchanged: 1,
+		},
+		{
+			name:          "removed from the middle",
+			oldChunks:     []models.Chunk{a, b, c},
+			newChunks:     []models.Chunk{a, c},
+			wantUnchanged: 2,
+			wantKinds:     []string{ChangeRemoved},
+			wantTexts:     []string{b.Text},
+		},
+		{
+			name:          "replaced section keeps its place",
+			oldChunks:     []models.Chunk{a, b, c},
+			newChunks:     []models.Chunk{a, d, c},
+			wantUnchanged: 2,
+			wantKinds:     []string{ChangeRemoved, ChangeAdded},
+			wantTexts:     []string{b.Text, d.Text},
+		},
+		{
+			name:          "reworded section is modified",
+			oldChunks:     []models.Chunk{a, chunk("the pump runs at five bar", "NarrativeText")},
+			newChunks:     []models.Chunk{a, chunk("the pump runs at six bar", "NarrativeText"), d},
+			wantUnchanged: 1,
+			wantKinds:     []string{ChangeModified, ChangeAdded},
+			wantTexts:     []string{"the pump runs at six bar", d.Text},
+		},
+		{
+			name:      "other element type is not paired",
+			oldChunks: []models.Chunk{chunk("the pump runs at five bar", "Title")},
+			newChunks: []models.Chunk{chunk("the pump runs at six bar", "NarrativeText")},
+			wantKinds: []string{ChangeRemoved, ChangeAdded},
+			wantTexts: []string{"the pump runs at five bar", "the pump runs at six bar"},
+		},
+		{
+			name:      "similar vectors pair a rewording",
+			oldChunks: []models.Chunk{embeddedChunk("start the engine", "m", 1, 0)},
+			newChunks: []models.Chunk{embeddedChunk("ignite the motor", "m", 0.99, 0.1)},
+			wantKinds: []string{ChangeModified},
+			wantTexts: []string{"ignite the motor"},
+		},
+		{
+			name:      "vectors of different models are not compared",
+			oldChunks: []models.Chunk{embeddedChunk("start the engine", "m", 1, 0)},
+			newChunks: []models.Chunk{embeddedChunk("ignite the motor", "other", 0.99, 0.1)},
+			wantKinds: []string{ChangeRemoved, ChangeAdded},
+			wantTexts: []string{"start the engine", "ignite the motor"},
+		},
+		{
+			name:          "duplicate sections pair once",
+			oldChunks:     []models.Chunk{a, a},
+			newChunks:     []models.Chunk{a},
+			wantUnchanged: 1,
+			wantKinds:     []string{ChangeRemoved},
+			wantTexts:     []string{a.Text},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diff := &VersionDiff{}
+			alignChunks(diff, tt.oldChunks, tt.newChunks)
+
+			var kinds, texts []string
+			counts := map[string]int{}
+			for _, change := range diff.Changes {
+				kinds = append(kinds, change.Kind)
+				counts[change.Kind]++
+				if change.Kind == ChangeRemoved {
+					texts = append(texts, change.FromText)
+				} else {
+					texts = append(texts, change.ToText)
+				}
+			}
+			if !reflect.DeepEqual(kinds, tt.wantKinds) || !reflect.DeepEqual(texts, tt.wantTexts) {
+				t.Errorf("changes = %v %q, want %v %q", kinds, texts, tt.wantKinds, tt.wantTexts)
+			}
+			if diff.Unchanged != tt.wantUnchanged {
+				t.Errorf("unchanged = %d, want %d", diff.Unchanged, tt.wantUnchanged)
+			}
+			if diff.Added != counts[ChangeAdded] || diff.Removed != counts[ChangeRemoved] || diff.Modified != counts[ChangeModified] {
+				t.Errorf("counts %d/%d/%d do not match the changes %v", diff.Added, diff.Removed, diff.Modified, counts)
+			}
+		})
+	}
+}
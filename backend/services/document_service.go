@@ -0,0 +1,82 @@
+package services
+
+import (
+	"bpt-knowledge-center/backend/models"
+	"bpt-knowledge-center/backend/repositories"
+	"context"
+	"log"
+	"os"
+)
+
+// TempDir is where uploads wait for ingestion, from TEMP_DIR (default ./temp)
+func TempDir() string {
+	dir := os.Getenv("TEMP_DIR")
+	if dir == "" {
+		dir = "./temp"
+	}
+	return dir
+}
+
+// DeleteDocument removes a document with its chunks and version history, every stored
+// version of its file and the temp files of its finished or failed ingestion jobs. Storage cleanup is best
+// effort once the record is gone; leftovers are found by Reconcile.
+func DeleteDocument(id string) error {
+	if err := repositories.DeleteDocument(id); err != nil {
+		return err
+	}
+
+	// Objects uploaded before versioned keys are stored under their bare filename,
+	// possibly shared with other documents, so those are left to Reconcile
+	if err := deleteObjects(ObjectPrefix(id) + "/"); err != nil {
+		log.Printf("Warning: Failed to delete stored files of %s: %v", id, err)
+	}
+
+	if err := repositories.DeleteDocumentVersions(id); err != nil {
+		log.Printf("Warning: Failed to delete version history of %s: %v", id, err)
+	}
+
+	jobs, err := repositories.GetJobsByDocument(id)
+	if err != nil {
+		log.Printf("Warning: Failed to load ingestion jobs of %s: %v", id, err)
+		return nil
+	}
+	for _, job := range jobs {
+		// Running jobs still read their temp files; they clean up when they finish
+		if job.Status != models.JobSaved && job.Status != models.JobFailed {
+			continue
+		}
+		removeJobFiles(&job)
+		if err := repositories.DeleteJob(job.ID); err != nil {
+			log.Printf("Warning: Failed to delete job %s: %v", job.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// deleteObjects removes every object under a key prefix
+func deleteObjects(prefix string) error {
+	objects, err := Storage.List(context.TODO(), prefix)
+	if err != nil {
+		return err
+	}
+
+	for _, obj := range objects {
+		if err := DeleteObject(obj.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeJobFiles deletes the local upload copy and parser checkpoint of a job
+func removeJobFiles(job *models.IngestionJob) {
+	for _, path := range []string{job.TempPath, job.ParsedPath} {
+		if path == "" {
+			continue
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Printf("Warning: Failed to remove %s: %v", path, err)
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Embedder turns text into vectors for the search index
+type Embedder interface {
+	// Embed returns the vector for a single text
+	Embed(ctx context.Context, text string) ([]float32, error)
+	// EmbedBatch returns one vector per text, in order
+	EmbedBatch(ctx context.Context, texts []string) ([][]float32, error)
+	// Dimension is the vector size the index expects (0 = not checked)
+	Dimension() int
+	// Model identifies the embedding model; vectors of different models are never mixed
+	Model() string
+}
+
+// Error kinds returned by embedders. Use errors.Is to tell them apart.
+var (
+	ErrEmbeddingUnavailable  = errors.New("embedding service unavailable")
+	ErrInvalidEmbeddingInput = errors.New("invalid embedding input")
+	ErrEmbeddingDimension    = errors.New("embedding dimension mismatch")
+	ErrEmbeddingModel        = errors.New("embedding model mismatch")
+)
+
+// EmbeddingError describes a failed embedding call
+type EmbeddingError struct {
+	Kind       error // one of the ErrEmbedding* values
+	StatusCode int   // HTTP status, 0 if the request never got a response
+	Err        error
+}
+
+func (e *EmbeddingError) Error() string {
+	if e.StatusCode != 0 {
+		return fmt.Sprintf("%v (status %d): %v", e.Kind, e.StatusCode, e.Err)
+	}
+	return fmt.Sprintf("%v: %v", e.Kind, e.Err)
+}
+
+func (e *EmbeddingError) Unwrap() []error {
+	return []error{e.Kind, e.Err}
+}
+
+// EmbeddingConfig holds the HTTP embedder settings read from the environment
+type EmbeddingConfig struct {
+	URL        string
+	BatchURL   string
+	Timeout    time.Duration
+	MaxRetries int
+	Backoff    time.Duration
+	Dimension  int
+	Model      string
+}
+
+// Embeddings is the embedder shared by all requests, set by InitEmbedder
+var Embeddings Embedder
+
+// LoadEmbeddingConfig reads EMBEDDING_* variables
+func LoadEmbeddingConfig() EmbeddingConfig {
+	cfg := EmbeddingConfig{
+		URL:        os.Getenv("EMBEDDING_URL"),
+		BatchURL:   os.Getenv("EMBEDDING_BATCH_URL"),
+		Timeout:    10 * time.Second,
+		MaxRetries: 3,
+		Backoff:    200 * time.Millisecond,
+		Dimension:  384, // all-MiniLM-L6-v2, the parser_service default
+		Model:      os.Getenv("EMBEDDING_MODEL"),
+	}
+	if cfg.Model == "" {
+		cfg.Model = "all-MiniLM-L6-v2"
+	}
+	if cfg.URL == "" {
+		cfg.URL = "http://localhost:8000/api/v1/embed"
+	}
+	if cfg.BatchURL == "" {
+		cfg.BatchURL = strings.TrimRight(cfg.URL, "/") + "/batch"
+	}
+	if v, err := time.ParseDuration(os.Getenv("EMBEDDING_TIMEOUT")); err == nil && v > 0 {
+		cfg.Timeout = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("EMBEDDING_MAX_RETRIES")); err == nil && v >= 0 {
+		cfg.MaxRetries = v
+	}
+	if v, err := time.ParseDuration(os.Getenv("EMBEDDING_RETRY_BACKOFF")); err == nil && v > 0 {
+		cfg.Backoff = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("EMBEDDING_DIMENSION")); err == nil && v >= 0 {
+		cfg.Dimension = v
+	}
+	return cfg
+}
+
+// InitEmbedder builds the embedder once at startup
+func InitEmbedder() {
+	cfg := LoadEmbeddingConfig()
+	Embeddings = NewHTTPEmbedder(cfg)
+	fmt.Printf("Using embedding service at %s (%s, dimension %d)\n", cfg.URL, cfg.Model, cfg.Dimension)
+}
+
+// httpEmbedder calls the parser_service embedding endpoints
+type httpEmbedder struct {
+	cfg    EmbeddingConfig
+	client *http.Client
+}
+
+// NewHTTPEmbedder creates an embedder for the parser_service HTTP API
+func NewHTTPEmbedder(cfg EmbeddingConfig) Embedder {
+	return &httpEmbedder{cfg: cfg, client: &http.Client{Timeout: cfg.Timeout}}
+}
+
+func (e *httpEmbedder) Dimension() int {
+	return e.cfg.Dimension
+}
+
+func (e *httpEmbedder) Model() string {
+	return e.cfg.Model
+}
+
+func (e *httpEmbedder) Embed(ctx context.Context, text string) ([]float32, error) {
+	if strings.TrimSpace(text) == "" {
+		return nil, &EmbeddingError{Kind: ErrInvalidEmbeddingInput, Err: errors.New("text is empty")}
+	}
+
+	var result struct {
+		Vector []float32 `json:"vector"`
+		Model  string    `json:"model"`
+	}
+	if err := e.post(ctx, e.cfg.URL, map[string]string{"text": text}, &result); err != nil {
+		return nil, err
+	}
+
+	if err := checkModel(result.Model, e.cfg.Model); err != nil {
+		return nil, err
+	}
+	if err := checkDimension(result.Vector, e.cfg.Dimension); err != nil {
+		return nil, err
+	}
+	return result.Vector, nil
+}
+
+func (e *httpEmbedder) EmbedBatch(ctx context.Context, texts []string) ([][]float32, error) {
+	if len(texts) == 0 {
+		return nil, nil
+	}
+	for i, text := range texts {
+		if strings.TrimSpace(text) == "" {
+			return nil, &EmbeddingError{Kind: ErrInvalidEmbeddingInput, Err: fmt.Errorf("text %d is empty", i)}
+		}
+	}
+
+	var result struct {
+		Vectors [][]float32 `json:"vectors"`
+		Model   string      `json:"model"`
+	}
+	if err := e.post(ctx, e.cfg.BatchURL, map[string][]string{"texts": texts}, &result); err != nil {
+		return nil, err
+	}
+
+	if err := checkModel(result.Model, e.cfg.Model); err != nil {
+		return nil, err
+	}
+	if len(result.Vectors) != len(texts) {
+		return nil, &EmbeddingError{
+			Kind: ErrEmbeddingUnavailable,
+			Err:  fmt.Errorf("expected %d vectors, got %d", len(texts), len(result.Vectors)),
+		}
+	}
+	for _, vec := range result.Vectors {
+		if err := checkDimension(vec, e.cfg.Dimension); err != nil {
+			return nil, err
+		}
+	}
+	return result.Vectors, nil
+}
+
+// post sends the payload, retrying with exponential backoff on network errors,
+// 429 and 5xx responses. Other 4xx responses are reported as invalid input.
+func (e *httpEmbedder) post(ctx context.Context, url string, payload interface{}, out interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return &EmbeddingError{Kind: ErrInvalidEmbeddingInput, Err: err}
+	}
+
+	backoff := e.cfg.Backoff
+	var lastErr error
+	for attempt := 0; attempt <= e.cfg.MaxRetries; attempt++ {
+		if attempt > 0 {
+			log.Printf("Embedding request failed, retrying in %v (attempt %d/%d): %v", backoff, attempt, e.cfg.MaxRetries, lastErr)
+			select {
+			case <-ctx.Done():
+				return &EmbeddingError{Kind: ErrEmbeddingUnavailable, Err: ctx.Err()}
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+
+		var retry bool
+		retry, lastErr = e.try(ctx, url, body, out)
+		if lastErr == nil || !retry {
+			return lastErr
+		}
+	}
+
+	return lastErr
+}
+
+// try performs one request and reports whether a failure is worth retrying
+func (e *httpEmbedder) try(ctx context.Context, url string, body []byte, out interface{}) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, &EmbeddingError{Kind: ErrEmbeddingUnavailable, Err: err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return ctx.Err() == nil, &EmbeddingError{Kind: ErrEmbeddingUnavailable, Err: err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		err := fmt.Errorf("%s", strings.TrimSpace(string(bodyBytes)))
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+			return true, &EmbeddingError{Kind: ErrEmbeddingUnavailable, StatusCode: resp.StatusCode, Err: err}
+		}
+		return false, &EmbeddingError{Kind: ErrInvalidEmbeddingInput, StatusCode: resp.StatusCode, Err: err}
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return false, &EmbeddingError{Kind: ErrEmbeddingUnavailable, Err: fmt.Errorf("failed to decode response: %w", err)}
+	}
+	return false, nil
+}
+
+// checkDimension verifies a vector has the size the index expects
+func checkDimension(vec []float32, expected int) error {
+	if len(vec) == 0 {
+		return &EmbeddingError{Kind: ErrEmbeddingDimension, Err: errors.New("empty vector")}
+	}
+	if expected > 0 && len(vec) != expected {
+		return &EmbeddingError{Kind: ErrEmbeddingDimension, Err: fmt.Errorf("expected %d, got %d", expected, len(vec))}
+	}
+	return nil
+}
+
+// checkModel verifies the service embedded with the configured model. Services that
+// do not report their model are trusted.
+func checkModel(reported string, expected string) error {
+	if reported != "" && expected != "" && reported != expected {
+		return &EmbeddingError{Kind: ErrEmbeddingModel, Err: fmt.Errorf("expected %s, service uses %s", expected, reported)}
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// embeddingServer answers the n-th request (from 0) with responses[n], repeating
+// the last one, and counts the requests
+func embeddingServer(t *testing.T, responses ...func(w http.ResponseWriter)) (*httptest.Server, *int32) {
+	t.Helper()
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(atomic.AddInt32(&calls, 1)) - 1
+		responses[min(n, len(responses)-1)](w)
+	}))
+	t.Cleanup(server.Close)
+	return server, &calls
+}
+
+func statusResponse(code int) func(w http.ResponseWriter) {
+	return func(w http.ResponseWriter) {
+		http.Error(w, http.StatusText(code), code)
+	}
+}
+
+func vectorOf(model string, dims int) func(w http.ResponseWriter) {
+	return func(w http.ResponseWriter) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"vector": make([]float32, dims), "model": model})
+	}
+}
+
+func TestHTTPEmbedderRetries(t *testing.T) {
+	tests := []struct {
+		name       string
+		responses  []func(w http.ResponseWriter)
+		wantKind   error // nil for success
+		wantStatus int
+		wantCalls  int32
+	}{
+		{"success", []func(http.ResponseWriter){vectorOf("m", 3)}, nil, 0, 1},
+		{"server error then success", []func(http.ResponseWriter){statusResponse(503), statusResponse(500), vectorOf("m", 3)}, nil, 0, 3},
+		{"rate limited until retries run out", []func(http.ResponseWriter){statusResponse(429)}, ErrEmbeddingUnavailable, 429, 3},
+		{"bad request is not retried", []func(http.ResponseWriter){statusResponse(400)}, ErrInvalidEmbeddingInput, 400, 1},
+		{"payload too large is not retried", []func(http.ResponseWriter){statusResponse(413)}, ErrInvalidEmbeddingInput, 413, 1},
+		{"undecodable response is not retried", []func(http.ResponseWriter){func(w http.ResponseWriter) { w.Write([]byte("{")) }}, ErrEmbeddingUnavailable, 0, 1},
+		{"wrong dimension", []func(http.ResponseWriter){vectorOf("m", 4)}, ErrEmbeddingDimension, 0, 1},
+		{"wrong model", []func(http.ResponseWriter){vectorOf("other", 3)}, ErrEmbeddingModel, 0, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, calls := embeddingServer(t, tt.responses...)
+			embedder := NewHTTPEmbedder(EmbeddingConfig{URL: server.URL, Timeout: time.Second, MaxRetries: 2, Backoff: time.Millisecond, Dimension: 3, Model: "m"})
+
+			vec, err := embedder.Embed(context.Background(), "text")
+
+			if got := atomic.LoadInt32(calls); got != tt.wantCalls {
+				t.Errorf("made %d requests, want %d", got, tt.wantCalls)
+			}
+			if tt.wantKind == nil {
+				if err != nil || len(vec) != 3 {
+					t.Fatalf("Embed = %v, %v; want a 3-dimensional vector", vec, err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.wantKind) {
+				t.Fatalf("err = %v, want %v", err, tt.wantKind)
+			}
+			var embeddingErr *EmbeddingError
+			if !errors.As(err, &embeddingErr) || embeddingErr.StatusCode != tt.wantStatus {
+				t.Errorf("err = %#v, want status %d", err, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestHTTPEmbedderBackoff(t *testing.T) {
+	server, calls := embeddingServer(t, statusResponse(503))
+	embedder := NewHTTPEmbedder(EmbeddingConfig{URL: server.URL, Timeout: time.Second, MaxRetries: 2, Backoff: 20 * time.Millisecond})
+
+	started := time.Now()
+	_, err := embedder.Embed(context.Background(), "text")
+	if !errors.Is(err, ErrEmbeddingUnavailable) {
+		t.Fatalf("err = %v, want %v", err, ErrEmbeddingUnavailable)
+	}
+	// Waits of 20ms and 40ms between the three attempts
+	if elapsed := time.Since(started); elapsed < 60*time.Millisecond {
+		t.Errorf("retries took %v, want at least 60ms of backoff", elapsed)
+	}
+	if got := atomic.LoadInt32(calls); got != 3 {
+		t.Errorf("made %d requests, want 3", got)
+	}
+}
+
+func TestHTTPEmbedderStopsRetryingWhenCancelled(t *testing.T) {
+	server, calls := embeddingServer(t, statusResponse(503))
+	embedder := NewHTTPEmbedder(EmbeddingConfig{URL: server.URL, Timeout: time.Second, MaxRetries: 5, Backoff: time.Hour})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := embedder.Embed(ctx, "text")
+
+	if !errors.Is(err, ErrEmbeddingUnavailable) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want an unavailable error caused by the deadline", err)
+	}
+	if got := atomic.LoadInt32(calls); got != 1 {
+		t.Errorf("made %d requests, want 1", got)
+	}
+}
+
+func TestHTTPEmbedderConnectionErrorsAreRetried(t *testing.T) {
+	server, _ := embeddingServer(t, statusResponse(503))
+	server.Close()
+	embedder := NewHTTPEmbedder(EmbeddingConfig{URL: server.URL, Timeout: time.Second, MaxRetries: 1, Backoff: time.Millisecond})
+
+	_, err := embedder.Embed(context.Background(), "text")
+	var embeddingErr *EmbeddingError
+	if !errors.As(err, &embeddingErr) || embeddingErr.Kind != ErrEmbeddingUnavailable || embeddingErr.StatusCode != 0 {
+		t.Errorf("err = %v, want an unavailable error without status", err)
+	}
+}
+
+func TestHTTPEmbedderRejectsEmptyInput(t *testing.T) {
+	server, calls := embeddingServer(t, vectorOf("", 3))
+	embedder := NewHTTPEmbedder(EmbeddingConfig{URL: server.URL, BatchURL: server.URL, Timeout: time.Second})
+
+	if _, err := embedder.Embed(context.Background(), "  "); !errors.Is(err, ErrInvalidEmbeddingInput) {
+		t.Errorf("Embed err = %v, want %v", err, ErrInvalidEmbeddingInput)
+	}
+	if _, err := embedder.EmbedBatch(context.Background(), []string{"text", ""}); !errors.Is(err, ErrInvalidEmbeddingInput) {
+		t.Errorf("EmbedBatch err = %v, want %v", err, ErrInvalidEmbeddingInput)
+	}
+	if got := atomic.LoadInt32(calls); got != 0 {
+		t.Errorf("made %d requests for empty input, want 0", got)
+	}
+}
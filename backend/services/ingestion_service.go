@@ -0,0 +1,417 @@
+package services
+
+import (
+	"bpt-knowledge-center/backend/models"
+	"bpt-knowledge-center/backend/repositories"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrQueueFull       = errors.New("ingestion queue is full")
+	ErrJobNotRetryable = errors.New("only failed jobs can be retried")
+)
+
+// ingestQueue holds the IDs of jobs waiting for a worker
+var ingestQueue chan string
+
+// StartIngestionWorkers starts INGEST_WORKERS (default 2) workers reading from a queue
+// of INGEST_QUEUE_SIZE (default 100) jobs, then re-queues jobs interrupted by a restart.
+func StartIngestionWorkers() {
+	workers := 2
+	if v, err := strconv.Atoi(os.Getenv("INGEST_WORKERS")); err == nil && v > 0 {
+		workers = v
+	}
+	queueSize := 100
+	if v, err := strconv.Atoi(os.Getenv("INGEST_QUEUE_SIZE")); err == nil && v > 0 {
+		queueSize = v
+	}
+
+	ingestQueue = make(chan string, queueSize)
+	for i := 0; i < workers; i++ {
+		go ingestionWorker()
+	}
+
+	go resumeUnfinishedJobs()
+
+	fmt.Printf("Started %d ingestion workers\n", workers)
+}
+
+// EnqueueIngestion hands a saved job to the worker pool without blocking
+func EnqueueIngestion(job *models.IngestionJob) error {
+	select {
+	case ingestQueue <- job.ID:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// RetryIngestion re-queues a failed job. It resumes after its last completed stage.
+func RetryIngestion(id string) (*models.IngestionJob, error) {
+	job, err := repositories.GetJobByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if job.Status != models.JobFailed {
+		return job, ErrJobNotRetryable
+	}
+
+	job.Status = models.JobQueued
+	job.Error = ""
+	if err := repositories.SaveJob(job); err != nil {
+		return nil, err
+	}
+
+	return job, EnqueueIngestion(job)
+}
+
+func ingestionWorker() {
+	for id := range ingestQueue {
+		runIngestionJob(id)
+	}
+}
+
+// resumeUnfinishedJobs re-queues jobs that were queued or running when the server stopped
+func resumeUnfinishedJobs() {
+	jobs, err := repositories.GetUnfinishedJobs()
+	if err != nil {
+		log.Printf("Warning: Failed to load unfinished ingestion jobs: %v", err)
+		return
+	}
+
+	for i := range jobs {
+		if err := EnqueueIngestion(&jobs[i]); err != nil {
+			log.Printf("Warning: Could not resume job %s: %v", jobs[i].ID, err)
+		}
+	}
+}
+
+func runIngestionJob(id string) {
+	job, err := repositories.GetJobByID(id)
+	if err != nil {
+		log.Printf("Failed to load ingestion job %s: %v", id, err)
+		return
+	}
+
+	job.Attempts++
+	if err := processIngestionJob(job); err != nil {
+		log.Printf("Ingestion job %s failed during %s: %v", job.ID, job.Status, err)
+		job.Status = models.JobFailed
+		job.Error = err.Error()
+		if err := repositories.SaveJob(job); err != nil {
+			log.Printf("Failed to record failure of job %s: %v", job.ID, err)
+		}
+	}
+}
+
+// processIngestionJob runs the remaining stages of a job: parse, embed, save.
+// After each stage the result is checkpointed so a retry can pick up from there.
+func processIngestionJob(job *models.IngestionJob) error {
+	var parsed *ParserResponse
+
+	// 1. Parse
+	if job.CompletedStage == "" {
+		if err := setJobStatus(job, models.JobParsing); err != nil {
+			return err
+		}
+
+		// Merged chunks and re-uploads are embedded here: element vectors from the
+		// parser do not fit merged chunks, and unchanged text can reuse its old vector
+		chunking := LoadChunkingConfig()
+		embed := chunking.Strategy == ChunkByElement && !job.IsReupload
+
+		var err error
+		parsed, err = SendToParser(job.TempPath, job.Filename, embed)
+		if err != nil {
+			return fmt.Errorf("parsing failed: %w", err)
+		}
+		if err := checkParserModel(parsed); err != nil {
+			return err
+		}
+		parsed.Data = ChunkElements(parsed.Data, chunking)
+		if err := completeStage(job, models.JobParsing, parsed); err != nil {
+			return err
+		}
+	} else if job.CompletedStage != models.JobSaved {
+		var err error
+		parsed, err = readParsedCheckpoint(job.ParsedPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	// 2. Embed any elements the parser returned without a vector
+	if job.CompletedStage == models.JobParsing {
+		if err := setJobStatus(job, models.JobEmbedding); err != nil {
+			return err
+		}
+		reused := 0
+		if job.IsReupload {
+			reused = reuseVectors(job.DocumentID, parsed)
+		}
+		embedded, err := embedMissingVectors(parsed)
+		if err != nil {
+			return fmt.Errorf("embedding failed: %w", err)
+		}
+		job.ChunksReused = reused
+		job.ChunksEmbedded = embedded
+		if err := completeStage(job, models.JobEmbedding, parsed); err != nil {
+			return err
+		}
+	}
+
+	// 3. Save
+	if job.CompletedStage == models.JobEmbedding {
+		doc, err := buildDocument(job, parsed)
+		if err != nil {
+			return err
+		}
+		if err := repositories.SaveDocument(doc); err != nil {
+			return fmt.Errorf("database save failed: %w", err)
+		}
+
+		if err := SyncShadowVectors(doc.ID, doc.Version); err != nil {
+			log.Printf("Warning: Failed to mirror vectors of %s v%d to the shadow index: %v", doc.ID, doc.Version, err)
+		}
+
+		// Without its record the version cannot be listed or restored; a retry saves
+		// the document again and the record is written once
+		if err := RecordVersion(doc, job.UploadedBy, job.ChangeNote); err != nil {
+			return fmt.Errorf("failed to record version %d: %w", doc.Version, err)
+		}
+
+		job.Version = doc.Version
+		job.ElementCount = doc.ElementCount
+		job.CompletedStage = models.JobSaved
+	}
+
+	job.Status = models.JobSaved
+	job.Error = ""
+	if err := repositories.SaveJob(job); err != nil {
+		return err
+	}
+
+	// The file is in object storage now; local copies are no longer needed
+	removeJobFiles(job)
+
+	return nil
+}
+
+// buildDocument turns the parser output into the document to save. Re-uploads keep
+// the document ID and metadata and bump the version.
+func buildDocument(job *models.IngestionJob, parsed *ParserResponse) (*models.Document, error) {
+	now := time.Now()
+	doc := models.Document{
+		ID:           job.DocumentID,
+		Type:         "document",
+		Filename:     job.Filename,
+		DisplayName:  job.DisplayName,
+		FileURL:      job.FileURL,
+		ObjectKey:    job.ObjectKey,
+		FileHash:     job.FileHash,
+		FileSize:     job.FileSize,
+		ContentType:  job.ContentType,
+		UploadedAt:   now,
+		UpdatedAt:    now,
+		ElementCount: parsed.ElementCount,
+		Version:      1,
+		DocType:      "knowledge-base.bpt-docs",
+		Chunks:       make([]models.DocumentChunk, len(parsed.Data)),
+	}
+
+	if doc.ContentType == "" {
+		doc.ContentType = parsed.ContentType
+	}
+	if job.Version > 0 {
+		doc.Version = job.Version
+	}
+
+	if job.IsReupload {
+		if existing, err := repositories.GetDocumentByID(job.DocumentID); err == nil {
+			if job.Version == 0 {
+				if doc.Version, err = NextVersion(existing); err != nil {
+					return nil, err
+				}
+			}
+			doc.Category = existing.Category
+			doc.Description = existing.Description
+			doc.Aliases = existing.Aliases
+		}
+	}
+
+	for i, item := range parsed.Data {
+		doc.Chunks[i] = models.DocumentChunk{
+			ChunkID:        item.ElementID,
+			Text:           item.Text,
+			Type:           item.Type,
+			Metadata:       item.Metadata,
+			Vector:         item.Vector,
+			Fingerprint:    TextFingerprint(item.Text),
+			EmbeddingModel: item.EmbeddingModel,
+		}
+		// Checkpoints written before models were recorded came from the configured one
+		if doc.Chunks[i].EmbeddingModel == "" && len(item.Vector) > 0 {
+			doc.Chunks[i].EmbeddingModel = Embeddings.Model()
+		}
+	}
+
+	return &doc, nil
+}
+
+// TextFingerprint identifies a chunk's text regardless of layout whitespace. Equal
+// fingerprints embed to the same vector.
+func TextFingerprint(text string) string {
+	sum := sha256.Sum256([]byte(strings.Join(strings.Fields(text), " ")))
+	return hex.EncodeToString(sum[:])
+}
+
+// checkParserModel refuses parser output embedded with another model than the
+// backend's, so vectors of different models never end up in one document
+func checkParserModel(parsed *ParserResponse) error {
+	if err := checkModel(parsed.EmbeddingModel, Embeddings.Model()); err != nil {
+		return fmt.Errorf("parsing failed: %w", err)
+	}
+
+	for i := range parsed.Data {
+		if len(parsed.Data[i].Vector) > 0 {
+			parsed.Data[i].EmbeddingModel = Embeddings.Model()
+		}
+	}
+	return nil
+}
+
+// reuseVectors copies vectors from the current version's chunks to parsed elements
+// with the same fingerprint and returns how many were reused
+func reuseVectors(documentID string, parsed *ParserResponse) int {
+	previous, err := repositories.GetChunksByDocument(documentID)
+	if err != nil {
+		log.Printf("Warning: Could not load previous chunks of %s, embedding everything: %v", documentID, err)
+		return 0
+	}
+	return copyVectors(previous, parsed)
+}
+
+// copyVectors gives parsed elements without a vector the vector of a previous chunk
+// with the same fingerprint and returns how many it filled in
+func copyVectors(previous []models.Chunk, parsed *ParserResponse) int {
+	vectors := make(map[string][]float32, len(previous))
+	for _, chunk := range previous {
+		// Only vectors of the current model can be mixed in
+		if len(chunk.Vector) == 0 || !sameEmbeddingModel(chunk) {
+			continue
+		}
+		fingerprint := chunk.Fingerprint
+		if fingerprint == "" {
+			fingerprint = TextFingerprint(chunk.Text)
+		}
+		vectors[fingerprint] = chunk.Vector
+	}
+
+	reused := 0
+	for i := range parsed.Data {
+		item := &parsed.Data[i]
+		if len(item.Vector) > 0 || item.Text == "" || isTitleElement(*item) {
+			continue
+		}
+		if vector, ok := vectors[TextFingerprint(item.Text)]; ok {
+			item.Vector = vector
+			item.EmbeddingModel = Embeddings.Model()
+			reused++
+		}
+	}
+
+	return reused
+}
+
+// sameEmbeddingModel reports whether a stored chunk's vector came from the configured
+// embedder. Chunks saved before models were recorded are judged by their dimension.
+func sameEmbeddingModel(chunk models.Chunk) bool {
+	if chunk.EmbeddingModel != "" {
+		return chunk.EmbeddingModel == Embeddings.Model()
+	}
+	return Embeddings.Dimension() == 0 || len(chunk.Vector) == Embeddings.Dimension()
+}
+
+// embeddingBatchSize reads EMBEDDING_BATCH_SIZE (default 32)
+func embeddingBatchSize() int {
+	if v, err := strconv.Atoi(os.Getenv("EMBEDDING_BATCH_SIZE")); err == nil && v > 0 {
+		return v
+	}
+	return 32
+}
+
+// embedMissingVectors fills in vectors for elements the parser did not embed, in
+// batches of EMBEDDING_BATCH_SIZE, and returns how many it embedded. Title-only
+// chunks are left without a vector.
+func embedMissingVectors(parsed *ParserResponse) (int, error) {
+	batchSize := embeddingBatchSize()
+
+	var pending []int
+	for i, item := range parsed.Data {
+		if len(item.Vector) == 0 && item.Text != "" && !isTitleElement(item) {
+			pending = append(pending, i)
+		}
+	}
+
+	for start := 0; start < len(pending); start += batchSize {
+		end := min(start+batchSize, len(pending))
+
+		texts := make([]string, 0, end-start)
+		for _, idx := range pending[start:end] {
+			texts = append(texts, parsed.Data[idx].Text)
+		}
+
+		vectors, err := Embeddings.EmbedBatch(context.Background(), texts)
+		if err != nil {
+			return 0, err
+		}
+		for j, idx := range pending[start:end] {
+			parsed.Data[idx].Vector = vectors[j]
+			parsed.Data[idx].EmbeddingModel = Embeddings.Model()
+		}
+	}
+
+	return len(pending), nil
+}
+
+func setJobStatus(job *models.IngestionJob, status string) error {
+	job.Status = status
+	return repositories.SaveJob(job)
+}
+
+// completeStage checkpoints the parser output and records the finished stage
+func completeStage(job *models.IngestionJob, stage string, parsed *ParserResponse) error {
+	data, err := json.Marshal(parsed)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(job.ParsedPath, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+
+	job.CompletedStage = stage
+	return repositories.SaveJob(job)
+}
+
+func readParsedCheckpoint(path string) (*ParserResponse, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+
+	var parsed ParserResponse
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		return nil, fmt.Errorf("failed to decode checkpoint: %w", err)
+	}
+	return &parsed, nil
+}
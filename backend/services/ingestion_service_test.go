@@ -0,0 +1,83 @@
+package services
+
+import (
+	"bpt-knowledge-center/backend/models"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTextFingerprint(t *testing.T) {
+	base := TextFingerprint("Press the red button.")
+
+	tests := []struct {
+		name string
+		text string
+		same bool
+	}{
+		{"identical", "Press the red button.", true},
+		{"layout whitespace", "  Press the\nred\tbutton.  ", true},
+		{"different case", "press the red button.", false},
+		{"different punctuation", "Press the red button!", false},
+		{"different words", "Press the blue button.", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := TextFingerprint(tt.text) == base; got != tt.same {
+				t.Errorf("fingerprint of %q equal = %v, want %v", tt.text, got, tt.same)
+			}
+		})
+	}
+}
+
+func TestCopyVectors(t *testing.T) {
+	previous := Embeddings
+	t.Cleanup(func() { Embeddings = previous })
+	Embeddings = NewHTTPEmbedder(EmbeddingConfig{Timeout: time.Second, Dimension: 2, Model: "m"})
+
+	stored := []models.Chunk{
+		{Text: "Clean the filter\nevery week.", Vector: []float32{1, 0}, EmbeddingModel: "m"},
+		{Text: "Replace the seals.", Vector: []float32{0, 1}, Fingerprint: TextFingerprint("Replace the seals.")},
+		{Text: "Old model text.", Vector: []float32{1, 1}, EmbeddingModel: "old"},
+		{Text: "Wrong size text.", Vector: []float32{1, 1, 1}},
+		{Text: "No vector text."},
+	}
+
+	tests := []struct {
+		name    string
+		element ParsedElement
+		want    []float32 // nil when no vector may be copied
+	}{
+		{"same text in another layout", ParsedElement{Type: "NarrativeText", Text: "Clean the filter every week."}, []float32{1, 0}},
+		{"stored fingerprint", ParsedElement{Type: "NarrativeText", Text: "Replace the seals."}, []float32{0, 1}},
+		{"changed text", ParsedElement{Type: "NarrativeText", Text: "Replace the seals twice."}, nil},
+		{"vector of another model", ParsedElement{Type: "NarrativeText", Text: "Old model text."}, nil},
+		{"legacy vector of another size", ParsedElement{Type: "NarrativeText", Text: "Wrong size text."}, nil},
+		{"chunk without vector", ParsedElement{Type: "NarrativeText", Text: "No vector text."}, nil},
+		{"title", ParsedElement{Type: titleElementType, Text: "Replace the seals."}, nil},
+		{"already embedded", ParsedElement{Type: "NarrativeText", Text: "Replace the seals.", Vector: []float32{5, 5}}, []float32{5, 5}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parsed := &ParserResponse{Data: []ParsedElement{tt.element}}
+			copied := copyVectors(stored, parsed)
+
+			item := parsed.Data[0]
+			if !reflect.DeepEqual(item.Vector, tt.want) {
+				t.Errorf("vector = %v, want %v", item.Vector, tt.want)
+			}
+			wantCopied := 0
+			if tt.want != nil && tt.element.Vector == nil {
+				wantCopied = 1
+			}
+			if copied != wantCopied {
+				t.Errorf("copied %d vectors, want %d", copied, wantCopied)
+			}
+			if wantCopied == 1 && item.EmbeddingModel != "m" {
+				t.Errorf("model = %q, want m", item.EmbeddingModel)
+			}
+		})
+	}
+}
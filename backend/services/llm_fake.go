@@ -0,0 +1,84 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// FakeGenerator is a deterministic generator for tests and local development.
+// By default it echoes the question found in the prompt.
+type FakeGenerator struct {
+	// Response, when set, is returned for every prompt
+	Response string
+	// FailAfter makes GenerateStream fail after that many deltas (-1 disables)
+	FailAfter int
+	// Prompts records every prompt received, in order
+	Prompts []string
+
+	mu sync.Mutex
+}
+
+// NewFakeGenerator reads LLM_FAKE_RESPONSE and LLM_FAKE_FAIL_AFTER
+func NewFakeGenerator() *FakeGenerator {
+	failAfter := -1
+	if v, err := strconv.Atoi(os.Getenv("LLM_FAKE_FAIL_AFTER")); err == nil {
+		failAfter = v
+	}
+	return &FakeGenerator{
+		Response:  os.Getenv("LLM_FAKE_RESPONSE"),
+		FailAfter: failAfter,
+	}
+}
+
+func (f *FakeGenerator) Generate(ctx context.Context, prompt string) (string, error) {
+	f.record(prompt)
+	return f.respond(prompt), nil
+}
+
+func (f *FakeGenerator) GenerateStream(ctx context.Context, prompt string, onDelta func(string) error) (string, error) {
+	f.record(prompt)
+
+	var sent strings.Builder
+	for i, word := range strings.SplitAfter(f.respond(prompt), " ") {
+		if f.FailAfter >= 0 && i >= f.FailAfter {
+			return sent.String(), fmt.Errorf("fake llm failure after %d deltas", f.FailAfter)
+		}
+		if err := ctx.Err(); err != nil {
+			return sent.String(), err
+		}
+		sent.WriteString(word)
+		if err := onDelta(word); err != nil {
+			return sent.String(), err
+		}
+	}
+
+	return sent.String(), nil
+}
+
+func (f *FakeGenerator) record(prompt string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Prompts = append(f.Prompts, prompt)
+}
+
+// respond returns the configured response or the last question in the prompt
+func (f *FakeGenerator) respond(prompt string) string {
+	if f.Response != "" {
+		return f.Response
+	}
+
+	lines := strings.Split(prompt, "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		lower := strings.ToLower(lines[i])
+		if idx := strings.Index(lower, "question:"); idx >= 0 {
+			if q := strings.Trim(lines[i][idx+len("question:"):], "* "); q != "" {
+				return q
+			}
+		}
+	}
+	return "fake answer"
+}
@@ -0,0 +1,95 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestFakeGeneratorStreamErrors(t *testing.T) {
+	errStop := errors.New("client went away")
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name       string
+		failAfter  int
+		ctx        context.Context
+		deltaErr   error
+		wantText   string
+		wantDeltas int
+		wantErr    error // nil means any error is accepted when wantFail is set
+		wantFail   bool
+	}{
+		{name: "completes", failAfter: -1, ctx: context.Background(), wantText: "one two three", wantDeltas: 3},
+		{name: "fails before the first delta", failAfter: 0, ctx: context.Background(), wantText: "", wantDeltas: 0, wantFail: true},
+		{name: "fails midway with partial text", failAfter: 2, ctx: context.Background(), wantText: "one two ", wantDeltas: 2, wantFail: true},
+		{name: "cancelled context", failAfter: -1, ctx: cancelled, wantText: "", wantDeltas: 0, wantErr: context.Canceled, wantFail: true},
+		{name: "delta callback error", failAfter: -1, ctx: context.Background(), deltaErr: errStop, wantText: "one ", wantDeltas: 1, wantErr: errStop, wantFail: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gen := &FakeGenerator{Response: "one two three", FailAfter: tt.failAfter}
+
+			deltas := 0
+			text, err := gen.GenerateStream(tt.ctx, "prompt", func(string) error {
+				deltas++
+				return tt.deltaErr
+			})
+
+			if tt.wantFail != (err != nil) {
+				t.Fatalf("err = %v, want failure %v", err, tt.wantFail)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("err = %v, want %v", err, tt.wantErr)
+			}
+			if text != tt.wantText {
+				t.Errorf("text = %q, want %q", text, tt.wantText)
+			}
+			if deltas != tt.wantDeltas {
+				t.Errorf("got %d deltas, want %d", deltas, tt.wantDeltas)
+			}
+			if len(gen.Prompts) != 1 || gen.Prompts[0] != "prompt" {
+				t.Errorf("recorded prompts %q, want [\"prompt\"]", gen.Prompts)
+			}
+		})
+	}
+}
+
+func TestNewFakeGeneratorFromEnv(t *testing.T) {
+	t.Setenv("LLM_FAKE_RESPONSE", "")
+	t.Setenv("LLM_FAKE_FAIL_AFTER", "not a number")
+	gen := NewFakeGenerator()
+	if gen.FailAfter != -1 {
+		t.Errorf("FailAfter = %d for an invalid value, want -1", gen.FailAfter)
+	}
+	answer, err := gen.Generate(context.Background(), "**User Question:** What is BPT?\n\n**Structured Answer:**")
+	if err != nil || answer != "What is BPT?" {
+		t.Errorf("Generate = %q, %v; want the question echoed", answer, err)
+	}
+
+	t.Setenv("LLM_FAKE_FAIL_AFTER", "1")
+	if gen := NewFakeGenerator(); gen.FailAfter != 1 {
+		t.Errorf("FailAfter = %d, want 1", gen.FailAfter)
+	}
+}
+
+func TestGenerateAnswerStreamReturnsPartialAnswer(t *testing.T) {
+	previous := LLM
+	t.Cleanup(func() { LLM = previous })
+	LLM = &FakeGenerator{Response: "alpha beta gamma", FailAfter: 1}
+
+	answer, err := GenerateAnswerStream(context.Background(), &Prompt{Text: "prompt"}, func(string) error { return nil })
+	if err == nil {
+		t.Fatal("expected the generator failure to be returned")
+	}
+	if answer != "alpha " {
+		t.Errorf("answer = %q, want the text streamed before the failure", answer)
+	}
+
+	LLM = nil
+	if answer, err := GenerateAnswerStream(context.Background(), &Prompt{Text: "prompt"}, nil); answer != "" || err != nil {
+		t.Errorf("without an LLM got %q, %v; want an empty answer", answer, err)
+	}
+}
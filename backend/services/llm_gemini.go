@@ -0,0 +1,100 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/google/generative-ai-go/genai"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+)
+
+// geminiGenerator talks to Google Gemini through a client created once at startup
+type geminiGenerator struct {
+	client *genai.Client
+	model  *genai.GenerativeModel
+	cfg    LLMConfig
+}
+
+// NewGeminiGenerator creates the Gemini client and model handle
+func NewGeminiGenerator(cfg LLMConfig) (Generator, error) {
+	if cfg.APIKey == "" {
+		return nil, fmt.Errorf("gemini API key is not set")
+	}
+
+	client, err := genai.NewClient(context.Background(), option.WithAPIKey(cfg.APIKey))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create gemini client: %w", err)
+	}
+
+	model := client.GenerativeModel(cfg.Model)
+	model.SetTemperature(cfg.Temperature)
+	model.SetMaxOutputTokens(int32(cfg.MaxTokens))
+
+	return &geminiGenerator{client: client, model: model, cfg: cfg}, nil
+}
+
+func (g *geminiGenerator) Generate(ctx context.Context, prompt string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, g.cfg.Timeout)
+	defer cancel()
+
+	resp, err := g.model.GenerateContent(ctx, genai.Text(prompt))
+	if err != nil {
+		return "", fmt.Errorf("gemini generation failed: %w", err)
+	}
+
+	if len(resp.Candidates) == 0 || resp.Candidates[0].Content == nil {
+		return "", fmt.Errorf("empty response from gemini")
+	}
+
+	return candidateText(resp), nil
+}
+
+func (g *geminiGenerator) GenerateStream(ctx context.Context, prompt string, onDelta func(string) error) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, g.cfg.Timeout)
+	defer cancel()
+
+	iter := g.model.GenerateContentStream(ctx, genai.Text(prompt))
+
+	var answer strings.Builder
+	for {
+		resp, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return answer.String(), fmt.Errorf("gemini stream failed: %w", err)
+		}
+
+		delta := candidateText(resp)
+		if delta == "" {
+			continue
+		}
+		answer.WriteString(delta)
+		if err := onDelta(delta); err != nil {
+			return answer.String(), err
+		}
+	}
+
+	if answer.Len() == 0 {
+		return "", fmt.Errorf("empty response from gemini")
+	}
+
+	return answer.String(), nil
+}
+
+// candidateText concatenates the text parts of the first candidate
+func candidateText(resp *genai.GenerateContentResponse) string {
+	if len(resp.Candidates) == 0 || resp.Candidates[0].Content == nil {
+		return ""
+	}
+
+	var text string
+	for _, part := range resp.Candidates[0].Content.Parts {
+		if txt, ok := part.(genai.Text); ok {
+			text += string(txt)
+		}
+	}
+	return text
+}
@@ -0,0 +1,155 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// openAIGenerator calls any OpenAI-compatible chat completions endpoint
+// (OpenAI, Ollama, vLLM, LM Studio, ...)
+type openAIGenerator struct {
+	cfg    LLMConfig
+	client *http.Client
+}
+
+type openAIMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type openAIRequest struct {
+	Model       string          `json:"model"`
+	Messages    []openAIMessage `json:"messages"`
+	Temperature float32         `json:"temperature"`
+	MaxTokens   int             `json:"max_tokens,omitempty"`
+	Stream      bool            `json:"stream"`
+}
+
+type openAIResponse struct {
+	Choices []struct {
+		Message openAIMessage `json:"message"`
+		Delta   openAIMessage `json:"delta"`
+	} `json:"choices"`
+}
+
+// NewOpenAIGenerator creates a generator for an OpenAI-compatible endpoint
+func NewOpenAIGenerator(cfg LLMConfig) (Generator, error) {
+	if cfg.Model == "" {
+		return nil, fmt.Errorf("LLM_MODEL is required for the openai provider")
+	}
+
+	// No client timeout: streams may legitimately run long, requests are bounded by context
+	return &openAIGenerator{cfg: cfg, client: &http.Client{}}, nil
+}
+
+func (g *openAIGenerator) Generate(ctx context.Context, prompt string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, g.cfg.Timeout)
+	defer cancel()
+
+	resp, err := g.send(ctx, prompt, false)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var result openAIResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("failed to decode completion: %w", err)
+	}
+	if len(result.Choices) == 0 {
+		return "", fmt.Errorf("empty response from %s", g.cfg.BaseURL)
+	}
+
+	return result.Choices[0].Message.Content, nil
+}
+
+func (g *openAIGenerator) GenerateStream(ctx context.Context, prompt string, onDelta func(string) error) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, g.cfg.Timeout)
+	defer cancel()
+
+	resp, err := g.send(ctx, prompt, true)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var answer strings.Builder
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if data == "[DONE]" {
+			break
+		}
+
+		var chunk openAIResponse
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return answer.String(), fmt.Errorf("failed to decode stream chunk: %w", err)
+		}
+		if len(chunk.Choices) == 0 || chunk.Choices[0].Delta.Content == "" {
+			continue
+		}
+
+		delta := chunk.Choices[0].Delta.Content
+		answer.WriteString(delta)
+		if err := onDelta(delta); err != nil {
+			return answer.String(), err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return answer.String(), fmt.Errorf("completion stream failed: %w", err)
+	}
+
+	if answer.Len() == 0 {
+		return "", fmt.Errorf("empty response from %s", g.cfg.BaseURL)
+	}
+
+	return answer.String(), nil
+}
+
+// send posts the chat completion request and checks the status code
+func (g *openAIGenerator) send(ctx context.Context, prompt string, stream bool) (*http.Response, error) {
+	payload, err := json.Marshal(openAIRequest{
+		Model:       g.cfg.Model,
+		Messages:    []openAIMessage{{Role: "user", Content: prompt}},
+		Temperature: g.cfg.Temperature,
+		MaxTokens:   g.cfg.MaxTokens,
+		Stream:      stream,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	url := strings.TrimRight(g.cfg.BaseURL, "/") + "/chat/completions"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if g.cfg.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+g.cfg.APIKey)
+	}
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call LLM endpoint: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		bodyBytes, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("LLM endpoint error (%d): %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	return resp, nil
+}
@@ -1,56 +1,198 @@
 package services
 
 import (
+	"bpt-knowledge-center/backend/models"
 	"context"
 	"fmt"
+	"log"
 	"os"
-
-	"github.com/google/generative-ai-go/genai"
-	"google.golang.org/api/option"
+	"strconv"
+	"strings"
+	"time"
 )
 
-// GenerateAnswer calls Google Gemini to generate a natural answer based on context.
-func GenerateAnswer(matches []ChunkMatch, question string) (string, error) {
-	// 1. Check for API Key (Try GOOGLE_API_KEY first as per user, then GEMINI_API_KEY)
-	apiKey := os.Getenv("GOOGLE_API_KEY")
-	if apiKey == "" {
-		apiKey = os.Getenv("GEMINI_API_KEY")
+// Generator produces text completions from a prompt
+type Generator interface {
+	// Generate returns the full completion for the prompt
+	Generate(ctx context.Context, prompt string) (string, error)
+	// GenerateStream calls onDelta for every text fragment as it arrives and
+	// returns the full completion once the stream completes
+	GenerateStream(ctx context.Context, prompt string, onDelta func(string) error) (string, error)
+}
+
+// LLMConfig holds the provider settings read from the environment
+type LLMConfig struct {
+	Provider    string // "gemini", "openai" or "fake"
+	Model       string
+	BaseURL     string // OpenAI-compatible endpoints only
+	APIKey      string
+	Temperature float32
+	MaxTokens   int
+	Timeout     time.Duration
+}
+
+// LLM is the generator shared by all requests. It is nil when no provider is
+// configured, in which case answers fall back to the raw chunks.
+var LLM Generator
+
+// LoadLLMConfig reads LLM_* variables, defaulting to Gemini
+func LoadLLMConfig() LLMConfig {
+	cfg := LLMConfig{
+		Provider:    strings.ToLower(os.Getenv("LLM_PROVIDER")),
+		Model:       os.Getenv("LLM_MODEL"),
+		BaseURL:     os.Getenv("LLM_BASE_URL"),
+		APIKey:      os.Getenv("LLM_API_KEY"),
+		Temperature: 0.2, // Low temperature for factual answers
+		MaxTokens:   2048,
+		Timeout:     120 * time.Second,
+	}
+	if cfg.Provider == "" {
+		cfg.Provider = "gemini"
+	}
+	if v, err := strconv.ParseFloat(os.Getenv("LLM_TEMPERATURE"), 32); err == nil {
+		cfg.Temperature = float32(v)
+	}
+	if v, err := strconv.Atoi(os.Getenv("LLM_MAX_TOKENS")); err == nil && v > 0 {
+		cfg.MaxTokens = v
+	}
+	if v, err := time.ParseDuration(os.Getenv("LLM_TIMEOUT")); err == nil && v > 0 {
+		cfg.Timeout = v
+	}
+
+	switch cfg.Provider {
+	case "gemini":
+		if cfg.Model == "" {
+			cfg.Model = "gemini-3-flash-preview"
+		}
+		// Try GOOGLE_API_KEY first, then GEMINI_API_KEY
+		if cfg.APIKey == "" {
+			cfg.APIKey = os.Getenv("GOOGLE_API_KEY")
+		}
+		if cfg.APIKey == "" {
+			cfg.APIKey = os.Getenv("GEMINI_API_KEY")
+		}
+	case "openai":
+		if cfg.BaseURL == "" {
+			cfg.BaseURL = "http://localhost:11434/v1"
+		}
+	case "fake":
+		if cfg.Model == "" {
+			cfg.Model = "fake"
+		}
+	}
+
+	return cfg
+}
+
+// NewGenerator builds the generator for the configured provider
+func NewGenerator(cfg LLMConfig) (Generator, error) {
+	switch cfg.Provider {
+	case "gemini":
+		return NewGeminiGenerator(cfg)
+	case "openai":
+		return NewOpenAIGenerator(cfg)
+	case "fake":
+		return NewFakeGenerator(), nil
+	default:
+		return nil, fmt.Errorf("unknown LLM provider %q", cfg.Provider)
 	}
+}
 
-	fmt.Printf("DEBUG: LLM Service called. Key Length: %d\n", len(apiKey))
+// InitLLM builds the configured generator once at startup. A missing Gemini key
+// is not fatal: answers fall back to the raw search results.
+func InitLLM() {
+	cfg := LoadLLMConfig()
 
-	if apiKey == "" {
-		fmt.Println("DEBUG: No API Key found. Skipping LLM.")
-		return "", nil // No key = No generation (Fallback to raw search)
+	if cfg.Provider == "gemini" && cfg.APIKey == "" {
+		log.Println("Warning: No Gemini API key found, answers will use raw search results")
+		return
 	}
 
-	ctx := context.Background()
-	client, err := genai.NewClient(ctx, option.WithAPIKey(apiKey))
+	gen, err := NewGenerator(cfg)
 	if err != nil {
-		fmt.Printf("DEBUG: Client Creation Error: %v\n", err)
-		return "", fmt.Errorf("failed to create gemini client: %w", err)
-	}
-	defer client.Close()
-
-	// 2. Select Model (User requested gemini-3-flash-preview)
-	model := client.GenerativeModel("gemini-3-flash-preview")
-	model.SetTemperature(0.2) // Low temperature for factual answers
-
-	// 3. Construct Prompt with source information
-	contextBlock := ""
-	for i, match := range matches {
-		sourceInfo := ""
-		if match.Source != "" {
-			sourceInfo = fmt.Sprintf(" (from %s", match.Source)
-			if match.Page > 0 {
-				sourceInfo += fmt.Sprintf(", Page %d", match.Page)
-			}
-			sourceInfo += ")"
+		log.Fatalf("Error: Failed to initialize LLM provider: %v", err)
+	}
+	LLM = gen
+
+	fmt.Printf("Using LLM provider %s (model %s)\n", cfg.Provider, cfg.Model)
+}
+
+// TrimHistory keeps the most recent messages that fit within CHAT_HISTORY_MAX_MESSAGES
+// (default 6) and CHAT_HISTORY_MAX_CHARS (default 4000)
+func TrimHistory(history []models.ChatMessage) []models.ChatMessage {
+	maxMessages := 6
+	if v, err := strconv.Atoi(os.Getenv("CHAT_HISTORY_MAX_MESSAGES")); err == nil && v >= 0 {
+		maxMessages = v
+	}
+	maxChars := 4000
+	if v, err := strconv.Atoi(os.Getenv("CHAT_HISTORY_MAX_CHARS")); err == nil && v >= 0 {
+		maxChars = v
+	}
+
+	start := len(history)
+	total := 0
+	for start > 0 && len(history)-start < maxMessages {
+		total += len(history[start-1].Content)
+		if total > maxChars {
+			break
 		}
-		contextBlock += fmt.Sprintf("Source %d%s:\n%s\n\n", i+1, sourceInfo, match.Text)
+		start--
+	}
+
+	return history[start:]
+}
+
+// formatHistory renders conversation turns as a plain transcript
+func formatHistory(history []models.ChatMessage) string {
+	var sb strings.Builder
+	for _, msg := range history {
+		role := "User"
+		if msg.Role == "assistant" {
+			role = "Assistant"
+		}
+		sb.WriteString(fmt.Sprintf("%s: %s\n", role, msg.Content))
+	}
+	return sb.String()
+}
+
+// RewriteQuery turns a follow-up question into a standalone search query using the
+// conversation history. Without history, or without an LLM, the message is returned as is.
+func RewriteQuery(history []models.ChatMessage, message string) (string, error) {
+	history = TrimHistory(history)
+	if len(history) == 0 {
+		return message, nil
+	}
+
+	prompt := fmt.Sprintf(`Rewrite the follow-up question so it can be understood without the conversation.
+Resolve pronouns and references such as "it", "that section" or "the second one" using the conversation.
+Keep the original language. Return ONLY the rewritten question, nothing else.
+
+Conversation:
+%s
+Follow-up question: %s
+
+Standalone question:`, formatHistory(history), message)
+
+	if LLM == nil {
+		return message, nil
+	}
+
+	rewritten, err := LLM.Generate(context.Background(), prompt)
+	if err != nil {
+		return message, err
 	}
 
-	prompt := fmt.Sprintf(`You are a high-level technical assistant for the BPT Knowledge Center.
+	rewritten = strings.TrimSpace(rewritten)
+	if rewritten == "" {
+		return message, nil
+	}
+	return rewritten, nil
+}
+
+// renderPrompt fills the grounded answer template with the numbered sources, the
+// recent conversation and the question
+func renderPrompt(contextBlock string, historyBlock string, question string) string {
+	return fmt.Sprintf(`You are a high-level technical assistant for the BPT Knowledge Center.
 Your goal is to provide a comprehensive, clear, and professional answer based **ONLY** on the context provided below.
 
 ### Formatting Guidelines:
@@ -60,8 +202,14 @@ Your goal is to provide a comprehensive, clear, and professional answer based **
 4. **Tone**: Maintain a professional, objective, and helpful tone.
 5. **Constraints**: 
    - If the information is not in the context, explicitly state: "I couldn't find that specific information in the available documents."
-   - Do not mention the context sources (e.g., "Source 1 says...") directly in the narrative unless necessary for clarity.
+   - Cite every fact inline with the number of the source it comes from, e.g. [1] or [2][3], placed right after the statement it supports.
+   - Only cite numbers that appear in the Context Documents; do not write "Source 1 says..." in the narrative.
    - Use standard Markdown formatting for best readability in a web interface.
+   - Use the conversation so far only to understand the question; facts must come from the context.
+
+---
+**Conversation So Far:**
+%s
 
 ---
 **Context Documents:**
@@ -70,28 +218,79 @@ Your goal is to provide a comprehensive, clear, and professional answer based **
 ---
 **User Question:** %s
 
-**Structured Answer:**`, contextBlock, question)
+**Structured Answer:**`, historyBlock, contextBlock, question)
+}
 
-	// 4. Generate
-	fmt.Println("DEBUG: Sending request to Gemini...")
-	resp, err := model.GenerateContent(ctx, genai.Text(prompt))
-	if err != nil {
-		fmt.Printf("DEBUG: Generation Error: %v\n", err)
-		return "", fmt.Errorf("gemini generation failed: %w", err)
+// GenerateAnswer asks the configured LLM for a natural answer to a built prompt.
+// It returns an empty answer when no provider is configured.
+func GenerateAnswer(prompt *Prompt) (string, error) {
+	if LLM == nil {
+		return "", nil // No provider = No generation (Fallback to raw search)
 	}
 
-	if len(resp.Candidates) == 0 || resp.Candidates[0].Content == nil {
-		fmt.Println("DEBUG: Empty response candidates")
-		return "", fmt.Errorf("empty response from gemini")
+	return LLM.Generate(context.Background(), prompt.Text)
+}
+
+// GenerateAnswerStream generates the answer incrementally, calling onDelta for every
+// text fragment as it arrives. It returns the full answer once the stream completes.
+func GenerateAnswerStream(ctx context.Context, prompt *Prompt, onDelta func(string) error) (string, error) {
+	if LLM == nil {
+		return "", nil
 	}
 
-	// Extract text
-	var answer string
-	for _, part := range resp.Candidates[0].Content.Parts {
-		if txt, ok := part.(genai.Text); ok {
-			answer += string(txt)
+	return LLM.GenerateStream(ctx, prompt.Text, onDelta)
+}
+
+// maxSummarizedChanges bounds how many changes are quoted in the summary prompt
+const maxSummarizedChanges = 40
+
+// SummarizeChanges asks the configured LLM to describe the differences between two
+// document versions for a reviewer. It returns "" when no provider is configured.
+func SummarizeChanges(ctx context.Context, diff *VersionDiff) (string, error) {
+	if LLM == nil || len(diff.Changes) == 0 {
+		return "", nil
+	}
+
+	var changes strings.Builder
+	for i, change := range diff.Changes {
+		if i == maxSummarizedChanges {
+			fmt.Fprintf(&changes, "... and %d more changes\n", len(diff.Changes)-i)
+			break
+		}
+
+		page := change.ToPage
+		if change.Kind == ChangeRemoved {
+			page = change.FromPage
+		}
+		fmt.Fprintf(&changes, "- %s %s", strings.ToUpper(change.Kind), change.ElementType)
+		if page > 0 {
+			fmt.Fprintf(&changes, " (page %d)", page)
+		}
+		changes.WriteString(":\n")
+		if change.FromText != "" {
+			fmt.Fprintf(&changes, "  before: %s\n", snippet(change.FromText, 400))
+		}
+		if change.ToText != "" {
+			fmt.Fprintf(&changes, "  after: %s\n", snippet(change.ToText, 400))
 		}
 	}
 
-	return answer, nil
+	prompt := fmt.Sprintf(`You are reviewing a revised policy document for the BPT Knowledge Center.
+Summarize what changed between version %d and version %d for a reviewer.
+
+### Guidelines:
+1. Start with one sentence on the overall nature of the revision.
+2. Then list the substantive changes as bullet points, citing page numbers where given.
+3. Ignore pure wording, formatting or ordering changes unless they change the meaning.
+4. Use **ONLY** the changes listed below; do not speculate.
+
+**Changes (%d added, %d removed, %d modified):**
+%s
+**Summary:**`, diff.From, diff.To, diff.Added, diff.Removed, diff.Modified, changes.String())
+
+	summary, err := LLM.Generate(ctx, prompt)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(summary), nil
 }
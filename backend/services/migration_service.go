@@ -0,0 +1,55 @@
+package services
+
+import (
+	"bpt-knowledge-center/backend/repositories"
+	"log"
+)
+
+// ChunkMigrationReport summarizes a run of MigrateChunkLayout
+type ChunkMigrationReport struct {
+	Documents int `json:"documents"`
+	Chunks    int `json:"chunks"`
+	Failed    int `json:"failed"`
+}
+
+// MigrateChunkLayout moves chunks embedded in legacy documents into separate chunk
+// documents and strips the nested array. Re-running it skips converted documents.
+func MigrateChunkLayout(dryRun bool) (ChunkMigrationReport, error) {
+	var report ChunkMigrationReport
+
+	// Chunks migrated before versioned chunks existed carry no active flag
+	if !dryRun {
+		if err := repositories.ActivateUnversionedChunks(); err != nil {
+			return report, err
+		}
+	}
+
+	ids, err := repositories.GetLegacyDocumentIDs()
+	if err != nil {
+		return report, err
+	}
+
+	for _, id := range ids {
+		doc, err := repositories.GetDocumentByID(id)
+		if err != nil {
+			log.Printf("Failed to load %s: %v", id, err)
+			report.Failed++
+			continue
+		}
+
+		log.Printf("Migrating %s (%s): %d chunks", doc.ID, doc.Filename, len(doc.Chunks))
+		if !dryRun {
+			// SaveDocument writes chunk documents and stores the document without the array
+			if err := repositories.SaveDocument(doc); err != nil {
+				log.Printf("Failed to migrate %s: %v", id, err)
+				report.Failed++
+				continue
+			}
+		}
+
+		report.Documents++
+		report.Chunks += len(doc.Chunks)
+	}
+
+	return report, nil
+}
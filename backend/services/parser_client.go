@@ -1,14 +1,13 @@
 package services
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
 	"mime/multipart"
 	"net/http"
 	"os"
-	"path/filepath"
+	"strconv"
 	"time"
 )
 
@@ -17,6 +16,8 @@ type ParserResponse struct {
 	ContentType  string          `json:"content_type"`
 	ElementCount int             `json:"element_count"`
 	Data         []ParsedElement `json:"data"`
+	// EmbeddingModel produced the vectors in Data; empty when the parser did not embed
+	EmbeddingModel string `json:"embedding_model"`
 }
 
 type ParsedElement struct {
@@ -25,9 +26,14 @@ type ParsedElement struct {
 	Text      string                 `json:"text"`
 	Metadata  map[string]interface{} `json:"metadata"`
 	Vector    []float32              `json:"vector"`
+	// EmbeddingModel produced Vector; set during ingestion, not by the parser
+	EmbeddingModel string `json:"embedding_model,omitempty"`
 }
 
-func SendToParser(filePath string) (*ParserResponse, error) {
+// SendToParser uploads a file to the parser service. The multipart body is streamed
+// from disk through a pipe; filename is the name the parser sees. Without embed the
+// parser returns elements without vectors.
+func SendToParser(filePath string, filename string, embed bool) (*ParserResponse, error) {
 	pythonURL := os.Getenv("PARSER_URL")
 	if pythonURL == "" {
 		return nil, fmt.Errorf("PARSER_URL not set in .env")
@@ -37,26 +43,41 @@ func SendToParser(filePath string) (*ParserResponse, error) {
 	if err != nil {
 		return nil, fmt.Errorf("could not open file: %v", err)
 	}
-	defer file.Close()
 
-	body := &bytes.Buffer{}
-	writer := multipart.NewWriter(body)
+	pr, pw := io.Pipe()
+	writer := multipart.NewWriter(pw)
+	go func() {
+		defer file.Close()
 
-	part, err := writer.CreateFormFile("file", filepath.Base(filePath))
-	if err != nil {
-		return nil, fmt.Errorf("could not create form file: %v", err)
-	}
+		err := writer.WriteField("embed", strconv.FormatBool(embed))
+		var part io.Writer
+		if err == nil {
+			part, err = writer.CreateFormFile("file", SafeFilename(filename))
+		}
+		if err == nil {
+			_, err = io.Copy(part, file)
+		}
+		if err == nil {
+			err = writer.Close()
+		}
+		// A failed write aborts the request instead of sending a truncated body
+		pw.CloseWithError(err)
+	}()
 
-	_, err = io.Copy(part, file)
+	req, err := http.NewRequest("POST", pythonURL, pr)
 	if err != nil {
-		return nil, fmt.Errorf("could not copy file content: %v", err)
+		pr.Close()
+		return nil, err
 	}
-	writer.Close()
-
-	req, _ := http.NewRequest("POST", pythonURL, body)
 	req.Header.Set("Content-Type", writer.FormDataContentType())
 
-	client := &http.Client{Timeout: 60 * time.Second}
+	// Parsing runs in the background, so large documents get a generous default
+	timeout := 10 * time.Minute
+	if v, err := time.ParseDuration(os.Getenv("PARSER_TIMEOUT")); err == nil && v > 0 {
+		timeout = v
+	}
+
+	client := &http.Client{Timeout: timeout}
 	resp, err := client.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to call Python service: %v", err)
@@ -0,0 +1,149 @@
+package services
+
+import (
+	"bpt-knowledge-center/backend/models"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// minTruncatedTokens is the smallest part of a source worth quoting when the rest of
+// it does not fit the budget
+const minTruncatedTokens = 50
+
+// Prompt is a grounded answer prompt with the sources it quotes, numbered 1..n in
+// order, and how its tokens were spent
+type Prompt struct {
+	Text    string          `json:"prompt"`
+	Matches []ChunkMatch    `json:"-"`
+	Tokens  PromptTokens    `json:"tokens"`
+	Omitted []OmittedSource `json:"omitted,omitempty"`
+	// Chunk keys of sources that are quoted, but cut short to fit the budget
+	Truncated []string `json:"truncated,omitempty"`
+}
+
+// PromptTokens accounts for the context window of the model
+type PromptTokens struct {
+	Model         string `json:"model"`
+	ContextWindow int    `json:"context_window"`
+	AnswerReserve int    `json:"answer_reserve"` // kept free for the answer
+	History       int    `json:"history"`
+	Template      int    `json:"template"` // instructions and question
+	ContextBudget int    `json:"context_budget"`
+	Context       int    `json:"context"`
+	Total         int    `json:"total"` // whole prompt, without the answer
+}
+
+// OmittedSource is a retrieved chunk left out of the prompt
+type OmittedSource struct {
+	ChunkKey string `json:"chunk_key"`
+	Reason   string `json:"reason"` // "duplicate" or "budget"
+}
+
+// defaultPromptContextTokens reads PROMPT_CONTEXT_TOKENS (default 6000), the most
+// tokens of sources put into a prompt
+func defaultPromptContextTokens() int {
+	if v, err := strconv.Atoi(os.Getenv("PROMPT_CONTEXT_TOKENS")); err == nil && v > 0 {
+		return v
+	}
+	return 6000
+}
+
+// promptDedupSimilarity reads PROMPT_DEDUP_SIMILARITY (default 0.9), the term overlap
+// above which a lower-ranked source repeats a higher-ranked one
+func promptDedupSimilarity() float64 {
+	if v, err := strconv.ParseFloat(os.Getenv("PROMPT_DEDUP_SIMILARITY"), 64); err == nil && v > 0 {
+		return v
+	}
+	return 0.9
+}
+
+// BuildPrompt assembles the answer prompt for the configured LLM. Matches are quoted
+// in rank order; near-identical passages are quoted once. The answer reserve
+// (LLM_MAX_TOKENS), the trimmed history and the instructions are subtracted from the
+// model's context window, and sources fill the rest up to PROMPT_CONTEXT_TOKENS; the
+// source crossing the limit is truncated, and later ones are left out.
+func BuildPrompt(matches []ChunkMatch, question string, history []models.ChatMessage) *Prompt {
+	cfg := LoadLLMConfig()
+	model := cfg.Model
+
+	historyBlock := "(none)"
+	if history = TrimHistory(history); len(history) > 0 {
+		historyBlock = formatHistory(history)
+	}
+
+	tokens := PromptTokens{
+		Model:         model,
+		ContextWindow: ContextWindow(model),
+		AnswerReserve: cfg.MaxTokens,
+		History:       CountTokens(model, historyBlock),
+		Template:      CountTokens(model, renderPrompt("", "", question)),
+	}
+	available := tokens.ContextWindow - tokens.AnswerReserve - tokens.History - tokens.Template
+	tokens.ContextBudget = max(0, min(defaultPromptContextTokens(), available))
+
+	prompt := &Prompt{}
+	var kept []map[string]bool
+	threshold := promptDedupSimilarity()
+
+	var contextBlock string
+	for _, match := range matches {
+		terms := termSet(match.Text)
+		if isNearDuplicate(terms, kept, threshold) {
+			prompt.Omitted = append(prompt.Omitted, OmittedSource{ChunkKey: match.ChunkKey, Reason: "duplicate"})
+			continue
+		}
+
+		remaining := tokens.ContextBudget - tokens.Context
+		block := sourceBlock(len(prompt.Matches)+1, match)
+		cost := CountTokens(model, block)
+		if cost > remaining {
+			header := CountTokens(model, sourceBlock(len(prompt.Matches)+1, ChunkMatch{Source: match.Source, Page: match.Page}))
+			if remaining-header < minTruncatedTokens {
+				prompt.Omitted = append(prompt.Omitted, OmittedSource{ChunkKey: match.ChunkKey, Reason: "budget"})
+				continue
+			}
+			match.Text = TruncateTokens(model, match.Text, remaining-header)
+			block = sourceBlock(len(prompt.Matches)+1, match)
+			cost = CountTokens(model, block)
+			prompt.Truncated = append(prompt.Truncated, match.ChunkKey)
+		}
+
+		contextBlock += block
+		tokens.Context += cost
+		kept = append(kept, terms)
+		prompt.Matches = append(prompt.Matches, match)
+	}
+
+	prompt.Text = renderPrompt(contextBlock, historyBlock, question)
+	tokens.Total = CountTokens(model, prompt.Text)
+	prompt.Tokens = tokens
+	return prompt
+}
+
+// sourceBlock renders one numbered source of the context
+func sourceBlock(n int, match ChunkMatch) string {
+	sourceInfo := ""
+	if match.Source != "" {
+		sourceInfo = fmt.Sprintf(" (from %s", match.Source)
+		if match.Page > 0 {
+			sourceInfo += fmt.Sprintf(", Page %d", match.Page)
+		}
+		sourceInfo += ")"
+	}
+	return fmt.Sprintf("Source [%d]%s:\n%s\n\n", n, sourceInfo, match.Text)
+}
+
+// isNearDuplicate reports whether a passage shares at least threshold of its terms
+// with a passage already in the prompt
+func isNearDuplicate(terms map[string]bool, kept []map[string]bool, threshold float64) bool {
+	if len(terms) == 0 {
+		return false
+	}
+	for _, other := range kept {
+		if jaccard(terms, other) >= threshold {
+			return true
+		}
+	}
+	return false
+}
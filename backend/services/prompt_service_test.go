@@ -0,0 +1,117 @@
+package services
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestBuildPromptBudget(t *testing.T) {
+	const model = "fake"
+	first := ChunkMatch{ChunkKey: "c1", Source: "a.pdf", Page: 1, Text: words(0, 100)}
+	second := ChunkMatch{ChunkKey: "c2", Source: "b.pdf", Page: 2, Text: words(100, 100)}
+	firstCost := CountTokens(model, sourceBlock(1, first))
+	secondCost := CountTokens(model, sourceBlock(2, second))
+	secondHeader := CountTokens(model, sourceBlock(2, ChunkMatch{Source: second.Source, Page: second.Page}))
+
+	tests := []struct {
+		name          string
+		window        int
+		reserve       int
+		budget        int
+		matches       []ChunkMatch
+		wantKept      []string
+		wantOmitted   []OmittedSource
+		wantTruncated []string
+	}{
+		{
+			name:     "everything fits",
+			budget:   firstCost + secondCost + 100,
+			matches:  []ChunkMatch{first, second},
+			wantKept: []string{"c1", "c2"},
+		},
+		{
+			name:     "exact fit",
+			budget:   firstCost + secondCost,
+			matches:  []ChunkMatch{first, second},
+			wantKept: []string{"c1", "c2"},
+		},
+		{
+			name:          "one token short truncates the last source",
+			budget:        firstCost + secondCost - 1,
+			matches:       []ChunkMatch{first, second},
+			wantKept:      []string{"c1", "c2"},
+			wantTruncated: []string{"c2"},
+		},
+		{
+			name:          "truncation keeps the smallest useful part",
+			budget:        firstCost + secondHeader + minTruncatedTokens,
+			matches:       []ChunkMatch{first, second},
+			wantKept:      []string{"c1", "c2"},
+			wantTruncated: []string{"c2"},
+		},
+		{
+			name:        "remainder too small to quote",
+			budget:      firstCost + secondHeader + minTruncatedTokens - 1,
+			matches:     []ChunkMatch{first, second},
+			wantKept:    []string{"c1"},
+			wantOmitted: []OmittedSource{{ChunkKey: "c2", Reason: "budget"}},
+		},
+		{
+			name:        "near-duplicate quoted once",
+			budget:      firstCost * 3,
+			matches:     []ChunkMatch{first, {ChunkKey: "c3", Source: "c.pdf", Text: first.Text}},
+			wantKept:    []string{"c1"},
+			wantOmitted: []OmittedSource{{ChunkKey: "c3", Reason: "duplicate"}},
+		},
+		{
+			name:        "answer reserve fills the window",
+			window:      1000,
+			reserve:     1000,
+			budget:      6000,
+			matches:     []ChunkMatch{first},
+			wantOmitted: []OmittedSource{{ChunkKey: "c1", Reason: "budget"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			window, reserve := tt.window, tt.reserve
+			if window == 0 {
+				window, reserve = 100000, 1000
+			}
+			t.Setenv("LLM_PROVIDER", "fake")
+			t.Setenv("LLM_MODEL", model)
+			t.Setenv("LLM_CONTEXT_WINDOW", strconv.Itoa(window))
+			t.Setenv("LLM_MAX_TOKENS", strconv.Itoa(reserve))
+			t.Setenv("PROMPT_CONTEXT_TOKENS", strconv.Itoa(tt.budget))
+
+			prompt := BuildPrompt(tt.matches, "What changed?", nil)
+
+			var kept []string
+			for _, match := range prompt.Matches {
+				kept = append(kept, match.ChunkKey)
+			}
+			if !reflect.DeepEqual(kept, tt.wantKept) {
+				t.Errorf("kept %v, want %v", kept, tt.wantKept)
+			}
+			if !reflect.DeepEqual(prompt.Omitted, tt.wantOmitted) {
+				t.Errorf("omitted %v, want %v", prompt.Omitted, tt.wantOmitted)
+			}
+			if !reflect.DeepEqual(prompt.Truncated, tt.wantTruncated) {
+				t.Errorf("truncated %v, want %v", prompt.Truncated, tt.wantTruncated)
+			}
+
+			tokens := prompt.Tokens
+			if tokens.Context > tokens.ContextBudget {
+				t.Errorf("context uses %d tokens, budget is %d", tokens.Context, tokens.ContextBudget)
+			}
+			if used := tokens.History + tokens.Template + tokens.Context; used > tokens.ContextWindow-tokens.AnswerReserve && len(prompt.Matches) > 0 {
+				t.Errorf("prompt uses %d tokens, %d are left after the answer reserve", used, tokens.ContextWindow-tokens.AnswerReserve)
+			}
+			if total := CountTokens(model, prompt.Text); tokens.Total != total {
+				t.Errorf("total = %d, prompt counts %d", tokens.Total, total)
+			}
+		})
+	}
+}
@@ -0,0 +1,157 @@
+package services
+
+import (
+	"bpt-knowledge-center/backend/repositories"
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ReconcileOptions controls what Reconcile changes. Without Repair it only reports.
+type ReconcileOptions struct {
+	Repair bool `json:"repair"`
+	// PruneDocuments also deletes documents whose file is missing from storage
+	PruneDocuments bool `json:"prune_documents"`
+}
+
+// ReconcileReport lists storage and database entries that no longer match
+type ReconcileReport struct {
+	OrphanedObjects   []string `json:"orphaned_objects"`
+	OrphanedTempFiles []string `json:"orphaned_temp_files"`
+	MissingObjects    []string `json:"missing_objects"` // IDs of documents whose file is gone
+	Repaired          int      `json:"repaired"`
+	Failed            int      `json:"failed"`
+}
+
+// reconcileGracePeriod reads RECONCILE_GRACE_PERIOD (default 1h). Newer objects and
+// temp files are skipped since their upload may still be in progress.
+func reconcileGracePeriod() time.Duration {
+	if v, err := time.ParseDuration(os.Getenv("RECONCILE_GRACE_PERIOD")); err == nil && v >= 0 {
+		return v
+	}
+	return time.Hour
+}
+
+// Reconcile compares documents, version records, ingestion jobs, object storage and the temp directory.
+// Objects and temp files that nothing references are orphans; documents whose current
+// file is not in storage are missing. With opts.Repair orphans are deleted.
+func Reconcile(opts ReconcileOptions) (*ReconcileReport, error) {
+	report := &ReconcileReport{
+		OrphanedObjects:   []string{},
+		OrphanedTempFiles: []string{},
+		MissingObjects:    []string{},
+	}
+	cutoff := time.Now().Add(-reconcileGracePeriod())
+
+	docs, err := repositories.GetDocumentFileRefs()
+	if err != nil {
+		return nil, err
+	}
+	versionKeys, err := repositories.GetVersionObjectKeys()
+	if err != nil {
+		return nil, err
+	}
+	jobs, err := repositories.GetRetainedJobs()
+	if err != nil {
+		return nil, err
+	}
+	objects, err := Storage.List(context.TODO(), "")
+	if err != nil {
+		return nil, err
+	}
+
+	// Everything a document, a version record or a pending job still points to
+	docPrefixes := make(map[string]bool)
+	referencedKeys := make(map[string]bool)
+	referencedFiles := make(map[string]bool)
+	for _, doc := range docs {
+		docPrefixes[ObjectPrefix(doc.ID)+"/"] = true
+		if doc.ObjectKey == "" {
+			referencedKeys[doc.Filename] = true
+		}
+	}
+	for _, key := range versionKeys {
+		referencedKeys[key] = true
+	}
+	for _, job := range jobs {
+		referencedKeys[job.ObjectKey] = true
+		referencedFiles[filepath.Clean(job.TempPath)] = true
+		referencedFiles[filepath.Clean(job.ParsedPath)] = true
+	}
+
+	stored := make(map[string]bool, len(objects))
+	for _, obj := range objects {
+		stored[obj.Key] = true
+		if referencedKeys[obj.Key] || obj.LastModified.After(cutoff) {
+			continue
+		}
+		if strings.HasPrefix(obj.Key, "documents/") && docPrefixes[documentPrefixOf(obj.Key)] {
+			continue
+		}
+		report.OrphanedObjects = append(report.OrphanedObjects, obj.Key)
+	}
+
+	for _, doc := range docs {
+		key := doc.ObjectKey
+		if key == "" {
+			key = doc.Filename
+		}
+		if !stored[key] {
+			report.MissingObjects = append(report.MissingObjects, doc.ID)
+		}
+	}
+
+	entries, err := os.ReadDir(TempDir())
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, entry := range entries {
+		path := filepath.Join(TempDir(), entry.Name())
+		info, err := entry.Info()
+		if err != nil || entry.IsDir() || referencedFiles[filepath.Clean(path)] || info.ModTime().After(cutoff) {
+			continue
+		}
+		report.OrphanedTempFiles = append(report.OrphanedTempFiles, path)
+	}
+
+	if opts.Repair {
+		repairOrphans(report, opts)
+	}
+
+	return report, nil
+}
+
+func repairOrphans(report *ReconcileReport, opts ReconcileOptions) {
+	record := func(what string, err error) {
+		if err != nil {
+			log.Printf("Reconcile: failed to remove %s: %v", what, err)
+			report.Failed++
+			return
+		}
+		report.Repaired++
+	}
+
+	for _, key := range report.OrphanedObjects {
+		record(key, DeleteObject(key))
+	}
+	for _, path := range report.OrphanedTempFiles {
+		record(path, os.Remove(path))
+	}
+	if opts.PruneDocuments {
+		for _, id := range report.MissingObjects {
+			record(id, DeleteDocument(id))
+		}
+	}
+}
+
+// documentPrefixOf returns the documents/<docID>/ part of a versioned object key
+func documentPrefixOf(key string) string {
+	parts := strings.SplitN(key, "/", 3)
+	if len(parts) < 3 {
+		return key
+	}
+	return parts[0] + "/" + parts[1] + "/"
+}
@@ -0,0 +1,440 @@
+package services
+
+import (
+	"bpt-knowledge-center/backend/models"
+	"bpt-knowledge-center/backend/repositories"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrReindexRunning     = errors.New("a reindex is already running")
+	ErrNothingToCutOver   = errors.New("no completed shadow reindex to cut over to")
+	ErrReindexNeedsShadow = errors.New("changing the vector dimension requires a shadow reindex")
+)
+
+// ReindexOptions configures a re-embedding of every chunk. Empty fields fall back to
+// the REINDEX_* and EMBEDDING_* settings.
+type ReindexOptions struct {
+	Model     string `json:"model"`     // target embedding model
+	Dimension int    `json:"dimension"` // vector size of the target model
+	// EmbeddingURL serves the target model. It only comes from server configuration
+	// (the -embedding-url flag or REINDEX_EMBEDDING_URL), never from a request body,
+	// as every chunk's text is sent to it.
+	EmbeddingURL string `json:"-"`
+	// Shadow builds into a separate index while the current one keeps serving
+	// searches; CutoverReindex then switches over. Without it chunks are rewritten in
+	// place, which is only possible if the dimension stays the same.
+	Shadow bool `json:"shadow"`
+	// LegacyModel produced the vectors of chunks saved before models were recorded
+	LegacyModel string        `json:"legacy_model"`
+	BatchSize   int           `json:"batch_size"`
+	BatchDelay  time.Duration `json:"-"`
+}
+
+// reindexActive guards against two runs in one process
+var reindexActive atomic.Bool
+
+// ReindexActive reports whether a reindex is running in this process
+func ReindexActive() bool {
+	return reindexActive.Load()
+}
+
+// GetReindexRun returns the progress of the latest reindex, or nil if none ran
+func GetReindexRun() (*models.ReindexRun, error) {
+	return repositories.GetReindexRun()
+}
+
+// resolveReindexOptions fills in defaults and builds the embedder of the target model
+func resolveReindexOptions(opts ReindexOptions) (ReindexOptions, Embedder) {
+	cfg := LoadEmbeddingConfig()
+	if opts.LegacyModel == "" {
+		opts.LegacyModel = cfg.Model
+	}
+
+	if opts.Model != "" {
+		cfg.Model = opts.Model
+	}
+	if opts.Dimension > 0 {
+		cfg.Dimension = opts.Dimension
+	}
+	if opts.EmbeddingURL == "" {
+		opts.EmbeddingURL = os.Getenv("REINDEX_EMBEDDING_URL")
+	}
+	if opts.EmbeddingURL != "" {
+		cfg.URL = opts.EmbeddingURL
+		cfg.BatchURL = strings.TrimRight(opts.EmbeddingURL, "/") + "/batch"
+	}
+	opts.Model = cfg.Model
+	opts.Dimension = cfg.Dimension
+	opts.EmbeddingURL = cfg.URL
+
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 64
+		if v, err := strconv.Atoi(os.Getenv("REINDEX_BATCH_SIZE")); err == nil && v > 0 {
+			opts.BatchSize = v
+		}
+	}
+	if opts.BatchDelay <= 0 {
+		opts.BatchDelay = 500 * time.Millisecond
+		if v, err := time.ParseDuration(os.Getenv("REINDEX_BATCH_DELAY")); err == nil && v >= 0 {
+			opts.BatchDelay = v
+		}
+	}
+
+	return opts, NewHTTPEmbedder(cfg)
+}
+
+// RunReindex re-embeds every chunk with the target model in rate-limited batches.
+// Progress is saved after each batch; running again with the same target resumes
+// after the last saved chunk. A shadow run ends in the "built" state, with queries of
+// the target model routed to the shadow index until CutoverReindex.
+func RunReindex(opts ReindexOptions) (*models.ReindexRun, error) {
+	if !reindexActive.CompareAndSwap(false, true) {
+		return nil, ErrReindexRunning
+	}
+	defer reindexActive.Store(false)
+
+	opts, embedder := resolveReindexOptions(opts)
+	slot := models.PrimarySlot
+	index := repositories.GetSearchIndexName()
+	if opts.Shadow {
+		slot = models.ShadowSlot
+		index = repositories.GetShadowSearchIndexName()
+	}
+
+	run, err := repositories.GetReindexRun()
+	if err != nil {
+		return nil, err
+	}
+
+	resume := run != nil && (run.Status == models.ReindexRunning || run.Status == models.ReindexFailed) &&
+		run.TargetModel == opts.Model && run.TargetDimension == opts.Dimension && run.Shadow == opts.Shadow
+	if resume {
+		log.Printf("Resuming reindex to %s after %s (%d/%d done)", run.TargetModel, run.Cursor, run.Processed, run.Total)
+		run.Status = models.ReindexRunning
+		run.Error = ""
+	} else {
+		run = &models.ReindexRun{
+			Status:          models.ReindexRunning,
+			TargetModel:     opts.Model,
+			TargetDimension: opts.Dimension,
+			EmbeddingURL:    opts.EmbeddingURL,
+			Shadow:          opts.Shadow,
+			Index:           index,
+			StartedAt:       time.Now(),
+		}
+		if err := prepareReindex(run, opts, slot); err != nil {
+			return nil, err
+		}
+	}
+	if err := repositories.SaveReindexRun(run); err != nil {
+		return nil, err
+	}
+
+	if err := reindexPass(run, embedder, slot, opts); err != nil {
+		return run, failReindex(run, err)
+	}
+	// Chunks saved behind the cursor still hold the old model's vectors in place
+	if !opts.Shadow {
+		if err := catchUpPass(run, embedder, slot, opts); err != nil {
+			return run, failReindex(run, err)
+		}
+	}
+
+	// Queries of the target model can now be served from the new vectors
+	routing, err := repositories.GetSearchRouting()
+	if err != nil {
+		return run, failReindex(run, err)
+	}
+	if routing == nil {
+		routing = &models.SearchRouting{}
+	}
+	route := models.SearchRoute{Model: opts.Model, Dimension: opts.Dimension, Index: index, Slot: slot.Name}
+	if opts.Shadow {
+		routing.Routes = append(withoutRoute(routing.Routes, opts.Model), route)
+		run.Status = models.ReindexBuilt
+	} else {
+		routing.Routes = []models.SearchRoute{route}
+		run.Status = models.ReindexDone
+		now := time.Now()
+		run.FinishedAt = &now
+	}
+	if err := repositories.SaveSearchRouting(routing); err != nil {
+		return run, failReindex(run, err)
+	}
+	InvalidateSearchRoutes()
+
+	log.Printf("Reindex to %s %s: %d chunks re-embedded, %d failed", run.TargetModel, run.Status, run.Processed, run.Failed)
+	return run, repositories.SaveReindexRun(run)
+}
+
+// prepareReindex records the model of legacy chunks, stores the routes in effect so
+// far and creates the index the run writes into
+func prepareReindex(run *models.ReindexRun, opts ReindexOptions, slot models.VectorSlot) error {
+	if err := repositories.BackfillEmbeddingModel(opts.LegacyModel); err != nil {
+		return fmt.Errorf("failed to record legacy embedding models: %w", err)
+	}
+
+	routing, err := repositories.GetSearchRouting()
+	if err != nil {
+		return err
+	}
+	if routing == nil {
+		// The primary index must map the model field before queries filter on it
+		legacy := LoadEmbeddingConfig()
+		if err := repositories.EnsureChunkSearchIndex(legacy.Dimension); err != nil {
+			return fmt.Errorf("failed to update search index: %w", err)
+		}
+		routing = &models.SearchRouting{Routes: []models.SearchRoute{{
+			Model:     opts.LegacyModel,
+			Dimension: legacy.Dimension,
+			Index:     repositories.GetSearchIndexName(),
+			Slot:      models.PrimarySlot.Name,
+		}}}
+	}
+
+	if opts.Shadow {
+		if err := repositories.EnsureSearchIndex(run.Index, slot, opts.Dimension); err != nil {
+			return fmt.Errorf("failed to create shadow index: %w", err)
+		}
+	} else {
+		// In place, both models share the primary index until every chunk is
+		// rewritten, told apart by the model filter. The index keeps serving the old
+		// model throughout, so its dimension cannot change.
+		if dims := primaryDimension(routing); dims > 0 && dims != opts.Dimension {
+			return fmt.Errorf("%w (index has %d, %s has %d)", ErrReindexNeedsShadow, dims, opts.Model, opts.Dimension)
+		}
+		routing.Routes = append(withoutRoute(routing.Routes, opts.Model), models.SearchRoute{
+			Model:     opts.Model,
+			Dimension: opts.Dimension,
+			Index:     run.Index,
+			Slot:      slot.Name,
+		})
+	}
+	if err := repositories.SaveSearchRouting(routing); err != nil {
+		return err
+	}
+	InvalidateSearchRoutes()
+
+	total, err := repositories.CountChunksToReindex(slot, opts.Model)
+	if err != nil {
+		return err
+	}
+	run.Total = total
+	return nil
+}
+
+// reindexPass embeds every chunk whose slot lacks a vector of the target model,
+// starting after run.Cursor. Chunks the service rejects are counted as failed and
+// skipped; an unavailable service stops the pass so it can resume later.
+func reindexPass(run *models.ReindexRun, embedder Embedder, slot models.VectorSlot, opts ReindexOptions) error {
+	for {
+		chunks, err := repositories.GetChunksToReindex(slot, opts.Model, run.Cursor, opts.BatchSize)
+		if err != nil {
+			return err
+		}
+		if len(chunks) == 0 {
+			return nil
+		}
+
+		vectors, err := embedReindexBatch(embedder, chunks)
+		if err != nil {
+			return err
+		}
+
+		for i, chunk := range chunks {
+			if vectors[i] == nil {
+				run.Failed++
+				continue
+			}
+			if err := repositories.SetChunkEmbedding(chunk.ID, slot, vectors[i], opts.Model); err != nil {
+				log.Printf("Warning: Failed to save vector of %s: %v", chunk.ID, err)
+				run.Failed++
+				continue
+			}
+			run.Processed++
+		}
+
+		run.Cursor = chunks[len(chunks)-1].ID
+		if err := repositories.SaveReindexRun(run); err != nil {
+			return err
+		}
+
+		time.Sleep(opts.BatchDelay)
+	}
+}
+
+// catchUpPass runs reindexPass again from the start, for chunks saved behind the
+// cursor. Chunks that failed before are retried, so failures are counted anew.
+func catchUpPass(run *models.ReindexRun, embedder Embedder, slot models.VectorSlot, opts ReindexOptions) error {
+	run.Cursor = ""
+	run.Failed = 0
+	return reindexPass(run, embedder, slot, opts)
+}
+
+// embedReindexBatch embeds a batch of chunks. When the service rejects the batch, the
+// chunks are embedded one by one so a single bad text does not fail the rest; the
+// vectors of rejected chunks are nil.
+func embedReindexBatch(embedder Embedder, chunks []models.Chunk) ([][]float32, error) {
+	texts := make([]string, len(chunks))
+	for i, chunk := range chunks {
+		texts[i] = chunk.Text
+	}
+
+	vectors, err := embedder.EmbedBatch(context.Background(), texts)
+	if err == nil {
+		return vectors, nil
+	}
+	if !errors.Is(err, ErrInvalidEmbeddingInput) {
+		return nil, err
+	}
+
+	vectors = make([][]float32, len(chunks))
+	for i, chunk := range chunks {
+		vector, err := embedder.Embed(context.Background(), chunk.Text)
+		if errors.Is(err, ErrInvalidEmbeddingInput) {
+			log.Printf("Warning: Skipping chunk %s: %v", chunk.ID, err)
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		vectors[i] = vector
+	}
+	return vectors, nil
+}
+
+// CutoverReindex makes a completed shadow reindex the primary index: chunks added
+// since the build are embedded, shadow vectors replace the primary ones, the primary
+// index is rebuilt and, once it caught up, queries are routed back to it. Searches
+// keep using the shadow index until then.
+func CutoverReindex(opts ReindexOptions) (*models.ReindexRun, error) {
+	if !reindexActive.CompareAndSwap(false, true) {
+		return nil, ErrReindexRunning
+	}
+	defer reindexActive.Store(false)
+
+	run, err := repositories.GetReindexRun()
+	if err != nil {
+		return nil, err
+	}
+	if run == nil || !run.Shadow || run.Status != models.ReindexBuilt {
+		return run, ErrNothingToCutOver
+	}
+
+	opts.Model = run.TargetModel
+	opts.Dimension = run.TargetDimension
+	opts.EmbeddingURL = run.EmbeddingURL
+	opts, embedder := resolveReindexOptions(opts)
+
+	// Catch up on chunks saved while the shadow index was built
+	run.Status = models.ReindexRunning
+	if err := catchUpPass(run, embedder, models.ShadowSlot, opts); err != nil {
+		return run, failReindex(run, err)
+	}
+
+	if err := repositories.PromoteShadowVectors(run.TargetModel); err != nil {
+		return run, failReindex(run, err)
+	}
+	// Chunks saved since the catch-up have no shadow vector to promote
+	if err := catchUpPass(run, embedder, models.PrimarySlot, opts); err != nil {
+		return run, failReindex(run, err)
+	}
+	if err := repositories.EnsureChunkSearchIndex(run.TargetDimension); err != nil {
+		return run, failReindex(run, err)
+	}
+	if err := waitForPrimaryIndex(); err != nil {
+		return run, failReindex(run, err)
+	}
+
+	routing := &models.SearchRouting{Routes: []models.SearchRoute{{
+		Model:     run.TargetModel,
+		Dimension: run.TargetDimension,
+		Index:     repositories.GetSearchIndexName(),
+		Slot:      models.PrimarySlot.Name,
+	}}}
+	if err := repositories.SaveSearchRouting(routing); err != nil {
+		return run, failReindex(run, err)
+	}
+	InvalidateSearchRoutes()
+
+	// Other servers may use the shadow index until their cached routes expire
+	time.Sleep(searchRoutingTTL)
+	if err := repositories.ClearShadowVectors(); err != nil {
+		log.Printf("Warning: Failed to clear shadow vectors: %v", err)
+	}
+	if err := repositories.DropSearchIndex(run.Index); err != nil {
+		log.Printf("Warning: Failed to drop shadow index %s: %v", run.Index, err)
+	}
+
+	run.Status = models.ReindexDone
+	run.Index = repositories.GetSearchIndexName()
+	now := time.Now()
+	run.FinishedAt = &now
+	log.Printf("Cut over to %s", run.TargetModel)
+	return run, repositories.SaveReindexRun(run)
+}
+
+// waitForPrimaryIndex blocks until the rebuilt primary index has ingested every chunk,
+// for at most REINDEX_CUTOVER_TIMEOUT (default 30m)
+func waitForPrimaryIndex() error {
+	timeout := 30 * time.Minute
+	if v, err := time.ParseDuration(os.Getenv("REINDEX_CUTOVER_TIMEOUT")); err == nil && v > 0 {
+		timeout = v
+	}
+	deadline := time.Now().Add(timeout)
+
+	total, err := repositories.CountChunks()
+	if err != nil {
+		return err
+	}
+
+	for {
+		indexed, err := repositories.IndexedDocumentCount(repositories.GetSearchIndexName())
+		if err == nil && indexed >= total {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("search index has %d of %d chunks after %v", indexed, total, timeout)
+		}
+		time.Sleep(5 * time.Second)
+	}
+}
+
+// failReindex records why a run stopped; it resumes from its cursor when restarted
+func failReindex(run *models.ReindexRun, cause error) error {
+	run.Status = models.ReindexFailed
+	run.Error = cause.Error()
+	if err := repositories.SaveReindexRun(run); err != nil {
+		log.Printf("Failed to record reindex failure: %v", err)
+	}
+	return cause
+}
+
+// withoutRoute drops the route of a model
+func withoutRoute(routes []models.SearchRoute, model string) []models.SearchRoute {
+	kept := make([]models.SearchRoute, 0, len(routes))
+	for _, route := range routes {
+		if route.Model != model {
+			kept = append(kept, route)
+		}
+	}
+	return kept
+}
+
+// primaryDimension returns the vector size the primary index was built for
+func primaryDimension(routing *models.SearchRouting) int {
+	for _, route := range routing.Routes {
+		if route.Slot == models.PrimarySlot.Name && route.Index == repositories.GetSearchIndexName() {
+			return route.Dimension
+		}
+	}
+	return 0
+}
@@ -0,0 +1,299 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Reranker rescores retrieved chunks against the question
+type Reranker interface {
+	// Rerank returns one relevance score per candidate, higher is better
+	Rerank(ctx context.Context, query string, candidates []ChunkMatch) ([]float64, error)
+	Name() string
+}
+
+// ActiveReranker is the reranker applied after retrieval, nil when reranking is off
+var ActiveReranker Reranker
+
+// RerankStats records what the reranking stage did to a result list
+type RerankStats struct {
+	Reranker   string  `json:"reranker"`
+	Candidates int     `json:"candidates"`
+	Kept       int     `json:"kept"`
+	DurationMs int64   `json:"duration_ms"`
+	Promoted   int     `json:"promoted"`    // chunks that moved into the top-k
+	MeanShift  float64 `json:"mean_shift"`  // average absolute rank change
+	MaxShift   int     `json:"max_shift"`   // largest absolute rank change
+	TopChanged bool    `json:"top_changed"` // whether the first result changed
+	// Mean and largest absolute score change of the kept chunks, see MatchScores.RerankDelta
+	MeanScoreDelta float64 `json:"mean_score_delta"`
+	MaxScoreDelta  float64 `json:"max_score_delta"`
+}
+
+// InitReranker builds the reranker named by RERANKER: "http" (cross-encoder served
+// by parser_service), "llm" (LLM judge), "fake" (term overlap) or "none" (default)
+func InitReranker() {
+	switch strings.ToLower(os.Getenv("RERANKER")) {
+	case "", "none":
+		return
+	case "http":
+		url := os.Getenv("RERANK_URL")
+		if url == "" {
+			url = "http://localhost:8000/api/v1/rerank"
+		}
+		timeout := 10 * time.Second
+		if v, err := time.ParseDuration(os.Getenv("RERANK_TIMEOUT")); err == nil && v > 0 {
+			timeout = v
+		}
+		ActiveReranker = NewHTTPReranker(url, timeout)
+	case "llm":
+		if LLM == nil {
+			log.Println("Warning: RERANKER=llm needs an LLM provider, reranking disabled")
+			return
+		}
+		ActiveReranker = NewLLMReranker(LLM)
+	case "fake":
+		ActiveReranker = &FakeReranker{}
+	default:
+		log.Fatalf("Error: Unknown reranker %q", os.Getenv("RERANKER"))
+	}
+
+	fmt.Printf("Using %s reranker over %d candidates\n", ActiveReranker.Name(), rerankCandidates())
+}
+
+// rerankCandidates reads RERANK_CANDIDATES (default 20), how many chunks to over-fetch
+func rerankCandidates() int {
+	if v, err := strconv.Atoi(os.Getenv("RERANK_CANDIDATES")); err == nil && v > 0 {
+		return min(v, 200)
+	}
+	return 20
+}
+
+// RerankMatches rescores the candidates, sorts them by the new score and keeps the best k
+func RerankMatches(ctx context.Context, reranker Reranker, query string, candidates []ChunkMatch, k int) ([]ChunkMatch, *RerankStats, error) {
+	started := time.Now()
+
+	scores, err := reranker.Rerank(ctx, query, candidates)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(scores) != len(candidates) {
+		return nil, nil, fmt.Errorf("reranker returned %d scores for %d candidates", len(scores), len(candidates))
+	}
+
+	// Retrieval and reranker scores have different scales; the delta compares their
+	// positions within the candidates instead
+	before := make([]float64, len(candidates))
+	for i, match := range candidates {
+		before[i] = match.Score
+	}
+	normBefore, normAfter := normalizeScores(before), normalizeScores(scores)
+
+	reranked := make([]ChunkMatch, len(candidates))
+	for i, match := range candidates {
+		match.Scores.Rerank = scores[i]
+		match.Scores.RerankDelta = normAfter[i] - normBefore[i]
+		match.Scores.RankBefore = i + 1
+		match.Score = scores[i]
+		reranked[i] = match
+	}
+	sort.SliceStable(reranked, func(i, j int) bool {
+		return reranked[i].Score > reranked[j].Score
+	})
+
+	if len(reranked) > k {
+		reranked = reranked[:k]
+	}
+
+	stats := &RerankStats{
+		Reranker:   reranker.Name(),
+		Candidates: len(candidates),
+		Kept:       len(reranked),
+		DurationMs: time.Since(started).Milliseconds(),
+		TopChanged: len(reranked) > 0 && reranked[0].Scores.RankBefore != 1,
+	}
+	totalShift, totalDelta := 0, 0.0
+	for i := range reranked {
+		reranked[i].Scores.RankAfter = i + 1
+		shift := reranked[i].Scores.RankBefore - reranked[i].Scores.RankAfter
+		shift = max(shift, -shift)
+		totalShift += shift
+		stats.MaxShift = max(stats.MaxShift, shift)
+		delta := math.Abs(reranked[i].Scores.RerankDelta)
+		totalDelta += delta
+		stats.MaxScoreDelta = max(stats.MaxScoreDelta, delta)
+		if reranked[i].Scores.RankBefore > k {
+			stats.Promoted++
+		}
+	}
+	if len(reranked) > 0 {
+		stats.MeanShift = float64(totalShift) / float64(len(reranked))
+		stats.MeanScoreDelta = totalDelta / float64(len(reranked))
+	}
+
+	log.Printf("Reranked %d candidates with %s in %dms (kept %d, promoted %d)", stats.Candidates, stats.Reranker, stats.DurationMs, stats.Kept, stats.Promoted)
+	return reranked, stats, nil
+}
+
+// normalizeScores scales scores to 0..1 by their minimum and maximum. Equal scores
+// all map to 0.
+func normalizeScores(scores []float64) []float64 {
+	normalized := make([]float64, len(scores))
+	if len(scores) == 0 {
+		return normalized
+	}
+	lo, hi := scores[0], scores[0]
+	for _, score := range scores {
+		lo, hi = min(lo, score), max(hi, score)
+	}
+	if hi == lo {
+		return normalized
+	}
+	for i, score := range scores {
+		normalized[i] = (score - lo) / (hi - lo)
+	}
+	return normalized
+}
+
+// httpReranker calls a cross-encoder endpoint: {"query", "texts"} -> {"scores"}
+type httpReranker struct {
+	url    string
+	client *http.Client
+}
+
+// NewHTTPReranker creates a reranker for the parser_service cross-encoder
+func NewHTTPReranker(url string, timeout time.Duration) Reranker {
+	return &httpReranker{url: url, client: &http.Client{Timeout: timeout}}
+}
+
+func (r *httpReranker) Name() string {
+	return "cross-encoder"
+}
+
+func (r *httpReranker) Rerank(ctx context.Context, query string, candidates []ChunkMatch) ([]float64, error) {
+	texts := make([]string, len(candidates))
+	for i, c := range candidates {
+		texts[i] = c.Text
+	}
+
+	payload, err := json.Marshal(map[string]interface{}{"query": query, "texts": texts})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call rerank service: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("rerank service error (%d): %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	var result struct {
+		Scores []float64 `json:"scores"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode rerank response: %w", err)
+	}
+
+	return result.Scores, nil
+}
+
+// llmReranker asks the configured LLM to grade each passage from 0 to 10
+type llmReranker struct {
+	generator Generator
+}
+
+// NewLLMReranker creates an LLM-judge reranker
+func NewLLMReranker(generator Generator) Reranker {
+	return &llmReranker{generator: generator}
+}
+
+func (r *llmReranker) Name() string {
+	return "llm-judge"
+}
+
+var scoreListPattern = regexp.MustCompile(`\[[\d\s.,]*\]`)
+
+func (r *llmReranker) Rerank(ctx context.Context, query string, candidates []ChunkMatch) ([]float64, error) {
+	var passages strings.Builder
+	for i, c := range candidates {
+		passages.WriteString(fmt.Sprintf("Passage %d:\n%s\n\n", i+1, c.Text))
+	}
+
+	prompt := fmt.Sprintf(`Rate how well each passage answers the question, from 0 (irrelevant) to 10 (fully answers it).
+Return ONLY a JSON array with one number per passage, in passage order, e.g. [7, 0, 3].
+
+Question: %s
+
+%s`, query, passages.String())
+
+	answer, err := r.generator.Generate(ctx, prompt)
+	if err != nil {
+		return nil, err
+	}
+
+	var scores []float64
+	if err := json.Unmarshal([]byte(scoreListPattern.FindString(answer)), &scores); err != nil {
+		return nil, fmt.Errorf("could not read scores from LLM answer: %q", answer)
+	}
+	return scores, nil
+}
+
+// FakeReranker is a deterministic reranker for tests. By default it scores each
+// candidate by how many query words its text contains.
+type FakeReranker struct {
+	ScoreFunc func(query string, text string) float64
+}
+
+func (r *FakeReranker) Name() string {
+	return "fake"
+}
+
+func (r *FakeReranker) Rerank(ctx context.Context, query string, candidates []ChunkMatch) ([]float64, error) {
+	scoreFunc := r.ScoreFunc
+	if scoreFunc == nil {
+		scoreFunc = termOverlap
+	}
+
+	scores := make([]float64, len(candidates))
+	for i, c := range candidates {
+		scores[i] = scoreFunc(query, c.Text)
+	}
+	return scores, nil
+}
+
+// termOverlap counts the distinct query words that appear in the text
+func termOverlap(query string, text string) float64 {
+	text = strings.ToLower(text)
+	seen := make(map[string]bool)
+	score := 0.0
+	for _, word := range strings.Fields(strings.ToLower(query)) {
+		if !seen[word] && strings.Contains(text, word) {
+			score++
+		}
+		seen[word] = true
+	}
+	return score
+}
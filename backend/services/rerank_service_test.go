@@ -0,0 +1,118 @@
+package services
+
+import (
+	"context"
+	"math"
+	"testing"
+)
+
+func TestRerankMatchesStats(t *testing.T) {
+	// Retrieval ranks a > b > c > d with scores 4..1
+	candidates := []ChunkMatch{
+		{ChunkKey: "a", Text: "a", Score: 4},
+		{ChunkKey: "b", Text: "b", Score: 3},
+		{ChunkKey: "c", Text: "c", Score: 2},
+		{ChunkKey: "d", Text: "d", Score: 1},
+	}
+
+	tests := []struct {
+		name      string
+		scores    map[string]float64
+		k         int
+		wantOrder []string
+		want      RerankStats
+	}{
+		{
+			name:      "same order",
+			scores:    map[string]float64{"a": 0.9, "b": 0.6, "c": 0.3, "d": 0},
+			k:         4,
+			wantOrder: []string{"a", "b", "c", "d"},
+			want:      RerankStats{Candidates: 4, Kept: 4},
+		},
+		{
+			name:      "reversed and cut to k",
+			scores:    map[string]float64{"a": 0, "b": 1, "c": 2, "d": 3},
+			k:         2,
+			wantOrder: []string{"d", "c"},
+			want: RerankStats{
+				Candidates: 4, Kept: 2, Promoted: 2, MeanShift: 2, MaxShift: 3, TopChanged: true,
+				MeanScoreDelta: 2.0 / 3, MaxScoreDelta: 1,
+			},
+		},
+		{
+			name:      "one candidate promoted to the top",
+			scores:    map[string]float64{"a": 2, "b": 1, "c": 0, "d": 4},
+			k:         3,
+			wantOrder: []string{"d", "a", "b"},
+			want: RerankStats{
+				Candidates: 4, Kept: 3, Promoted: 1, MeanShift: 5.0 / 3, MaxShift: 3, TopChanged: true,
+				MeanScoreDelta: (1 + 0.5 + (2.0/3 - 0.25)) / 3, MaxScoreDelta: 1,
+			},
+		},
+		{
+			name:      "ties keep the retrieval order",
+			scores:    map[string]float64{},
+			k:         2,
+			wantOrder: []string{"a", "b"},
+			want: RerankStats{
+				Candidates: 4, Kept: 2,
+				MeanScoreDelta: (1 + 2.0/3) / 2, MaxScoreDelta: 1,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reranker := &FakeReranker{ScoreFunc: func(query string, text string) float64 {
+				return tt.scores[text]
+			}}
+
+			reranked, stats, err := RerankMatches(context.Background(), reranker, "query", candidates, tt.k)
+			if err != nil {
+				t.Fatalf("RerankMatches: %v", err)
+			}
+
+			if len(reranked) != len(tt.wantOrder) {
+				t.Fatalf("kept %d matches, want %d", len(reranked), len(tt.wantOrder))
+			}
+			for i, key := range tt.wantOrder {
+				if reranked[i].ChunkKey != key {
+					t.Errorf("rank %d is %s, want %s", i+1, reranked[i].ChunkKey, key)
+				}
+				if reranked[i].Scores.RankAfter != i+1 {
+					t.Errorf("%s has RankAfter %d, want %d", key, reranked[i].Scores.RankAfter, i+1)
+				}
+			}
+
+			got := *stats
+			got.Reranker, got.DurationMs = "", 0
+			if !statsEqual(got, tt.want) {
+				t.Errorf("stats = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRerankMatchesScoreCountMismatch(t *testing.T) {
+	reranker := rerankerFunc(func([]ChunkMatch) []float64 { return []float64{1} })
+	if _, _, err := RerankMatches(context.Background(), reranker, "query", make([]ChunkMatch, 2), 2); err == nil {
+		t.Fatal("expected an error when the reranker returns fewer scores than candidates")
+	}
+}
+
+// rerankerFunc adapts a function to the Reranker interface
+type rerankerFunc func([]ChunkMatch) []float64
+
+func (f rerankerFunc) Name() string { return "func" }
+
+func (f rerankerFunc) Rerank(ctx context.Context, query string, candidates []ChunkMatch) ([]float64, error) {
+	return f(candidates), nil
+}
+
+func statsEqual(a, b RerankStats) bool {
+	near := func(x, y float64) bool { return math.Abs(x-y) < 1e-9 }
+	return a.Reranker == b.Reranker && a.Candidates == b.Candidates && a.Kept == b.Kept &&
+		a.Promoted == b.Promoted && a.MaxShift == b.MaxShift && a.TopChanged == b.TopChanged &&
+		near(a.MeanShift, b.MeanShift) && near(a.MeanScoreDelta, b.MeanScoreDelta) &&
+		near(a.MaxScoreDelta, b.MaxScoreDelta)
+}
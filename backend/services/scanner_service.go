@@ -0,0 +1,192 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// Scanner checks uploaded files for malware before they are stored
+type Scanner interface {
+	// Scan returns the name of the threat found in the file, or "" when it is clean
+	Scan(ctx context.Context, path string) (string, error)
+	Name() string
+}
+
+// ActiveScanner scans every upload, nil when scanning is off
+var ActiveScanner Scanner
+
+// InitScanner builds the scanner named by SCANNER: "clamd" (ClamAV daemon at
+// CLAMD_ADDRESS), "fake" (matches test signatures) or "none" (default)
+func InitScanner() {
+	switch strings.ToLower(os.Getenv("SCANNER")) {
+	case "", "none":
+		return
+	case "clamd":
+		address := os.Getenv("CLAMD_ADDRESS")
+		if address == "" {
+			address = "tcp://localhost:3310"
+		}
+		timeout := 60 * time.Second
+		if v, err := time.ParseDuration(os.Getenv("CLAMD_TIMEOUT")); err == nil && v > 0 {
+			timeout = v
+		}
+		ActiveScanner = NewClamdScanner(address, timeout)
+	case "fake":
+		ActiveScanner = NewFakeScanner()
+	default:
+		log.Fatalf("Error: Unknown scanner %q", os.Getenv("SCANNER"))
+	}
+
+	fmt.Printf("Scanning uploads with %s\n", ActiveScanner.Name())
+}
+
+// ScanUpload runs the active scanner over a spooled upload
+func ScanUpload(ctx context.Context, upload *TempUpload) error {
+	if ActiveScanner == nil {
+		return nil
+	}
+
+	threat, err := ActiveScanner.Scan(ctx, upload.Path)
+	if err != nil {
+		log.Printf("Scan of %s failed: %v", upload.Path, err)
+		return &UploadError{UploadScanFailed, "File could not be scanned, try again later"}
+	}
+	if threat != "" {
+		log.Printf("Rejected upload %s: %s", upload.Path, threat)
+		return &UploadError{UploadInfected, "File was rejected by the virus scanner: " + threat}
+	}
+	return nil
+}
+
+// ClamdScanner streams files to a ClamAV daemon with the INSTREAM command
+type ClamdScanner struct {
+	network string
+	address string
+	timeout time.Duration
+}
+
+// NewClamdScanner accepts "unix:///path/to/clamd.sock" or "tcp://host:port"
+func NewClamdScanner(address string, timeout time.Duration) *ClamdScanner {
+	network := "tcp"
+	if path, ok := strings.CutPrefix(address, "unix://"); ok {
+		network, address = "unix", path
+	} else {
+		address = strings.TrimPrefix(address, "tcp://")
+	}
+	return &ClamdScanner{network: network, address: address, timeout: timeout}
+}
+
+func (s *ClamdScanner) Name() string {
+	return "clamd"
+}
+
+func (s *ClamdScanner) Scan(ctx context.Context, path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	dialer := net.Dialer{Timeout: s.timeout}
+	conn, err := dialer.DialContext(ctx, s.network, s.address)
+	if err != nil {
+		return "", fmt.Errorf("failed to connect to clamd: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(s.timeout))
+
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return "", err
+	}
+
+	// The stream is a series of length-prefixed chunks ended by a zero length
+	chunk := make([]byte, 32<<10)
+	size := make([]byte, 4)
+	for {
+		n, err := file.Read(chunk)
+		if n > 0 {
+			binary.BigEndian.PutUint32(size, uint32(n))
+			if _, err := conn.Write(size); err != nil {
+				return "", err
+			}
+			if _, err := conn.Write(chunk[:n]); err != nil {
+				return "", err
+			}
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return "", err
+		}
+	}
+	if _, err := conn.Write([]byte{0, 0, 0, 0}); err != nil {
+		return "", err
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && err != io.EOF {
+		return "", fmt.Errorf("failed to read clamd reply: %v", err)
+	}
+	return parseClamdReply(strings.TrimRight(reply, "\x00\n"))
+}
+
+// parseClamdReply reads "stream: OK" or "stream: <signature> FOUND"
+func parseClamdReply(reply string) (string, error) {
+	result := strings.TrimSpace(strings.TrimPrefix(reply, "stream:"))
+	switch {
+	case result == "OK":
+		return "", nil
+	case strings.HasSuffix(result, " FOUND"):
+		return strings.TrimSuffix(result, " FOUND"), nil
+	default:
+		return "", fmt.Errorf("clamd error: %s", reply)
+	}
+}
+
+// eicarSignature is the standard antivirus test string
+const eicarSignature = `X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`
+
+// FakeScanner flags files containing the EICAR test string or any of Signatures,
+// for running without ClamAV
+type FakeScanner struct {
+	Signatures []string
+}
+
+// NewFakeScanner adds the comma-separated FAKE_SCAN_SIGNATURES to the EICAR string
+func NewFakeScanner() *FakeScanner {
+	signatures := []string{eicarSignature}
+	for _, s := range strings.Split(os.Getenv("FAKE_SCAN_SIGNATURES"), ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			signatures = append(signatures, s)
+		}
+	}
+	return &FakeScanner{Signatures: signatures}
+}
+
+func (s *FakeScanner) Name() string {
+	return "fake"
+}
+
+func (s *FakeScanner) Scan(ctx context.Context, path string) (string, error) {
+	for _, sig := range s.Signatures {
+		found, err := fileContains(path, []byte(sig))
+		if err != nil {
+			return "", err
+		}
+		if found {
+			if sig == eicarSignature {
+				return "Eicar-Test-Signature", nil
+			}
+			return "Fake-Signature." + sig, nil
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,90 @@
+package services
+
+import (
+	"bpt-knowledge-center/backend/models"
+	"bpt-knowledge-center/backend/repositories"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// searchRoutingTTL bounds how long a reindex cutover takes to reach running servers
+const searchRoutingTTL = 30 * time.Second
+
+var routingCache struct {
+	sync.Mutex
+	routing  *models.SearchRouting
+	loadedAt time.Time
+}
+
+// loadSearchRouting returns the stored routes, cached for searchRoutingTTL. A nil
+// routing means no reindex ever ran and the primary index serves every query.
+func loadSearchRouting() (*models.SearchRouting, error) {
+	routingCache.Lock()
+	defer routingCache.Unlock()
+
+	if !routingCache.loadedAt.IsZero() && time.Since(routingCache.loadedAt) < searchRoutingTTL {
+		return routingCache.routing, nil
+	}
+
+	routing, err := repositories.GetSearchRouting()
+	if err != nil {
+		return nil, err
+	}
+	routingCache.routing = routing
+	routingCache.loadedAt = time.Now()
+	return routing, nil
+}
+
+// InvalidateSearchRoutes makes the next search reload the stored routes
+func InvalidateSearchRoutes() {
+	routingCache.Lock()
+	routingCache.loadedAt = time.Time{}
+	routingCache.Unlock()
+}
+
+// SearchRouteFor returns the index and vector slot that hold vectors of the model.
+// Queries embedded with a model no index holds are refused rather than compared
+// against vectors of another model.
+func SearchRouteFor(model string, dimension int) (*models.SearchRoute, error) {
+	routing, err := loadSearchRouting()
+	if err != nil {
+		return nil, err
+	}
+
+	// Before the first reindex, chunks carry no model to filter on
+	if routing == nil {
+		return &models.SearchRoute{
+			Index: repositories.GetSearchIndexName(),
+			Slot:  models.PrimarySlot.Name,
+		}, nil
+	}
+
+	for _, route := range routing.Routes {
+		if route.Model != model {
+			continue
+		}
+		if route.Dimension > 0 && dimension > 0 && route.Dimension != dimension {
+			return nil, &EmbeddingError{
+				Kind: ErrEmbeddingDimension,
+				Err:  fmt.Errorf("index %s holds %d-dimensional vectors of %s, query has %d", route.Index, route.Dimension, model, dimension),
+			}
+		}
+		return &route, nil
+	}
+
+	return nil, &EmbeddingError{
+		Kind: ErrEmbeddingModel,
+		Err:  fmt.Errorf("no search index holds vectors of %s", model),
+	}
+}
+
+// SyncShadowVectors mirrors a saved document version into the shadow slot when the
+// shadow index serves the configured model, so it is not missing after a cutover
+func SyncShadowVectors(documentID string, version int) error {
+	route, err := SearchRouteFor(Embeddings.Model(), Embeddings.Dimension())
+	if err != nil || route.Slot != models.ShadowSlot.Name {
+		return nil
+	}
+	return repositories.CopyChunkVectorsToShadow(documentID, version)
+}
@@ -2,139 +2,399 @@ package services
 
 import (
 	"bpt-knowledge-center/backend/config"
-	"bytes"
-	"encoding/json"
-	"fmt"
+	"bpt-knowledge-center/backend/models"
+	"bpt-knowledge-center/backend/repositories"
+	"context"
 	"log"
-	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/couchbase/gocb/v2"
+	"github.com/couchbase/gocb/v2/search"
 	"github.com/couchbase/gocb/v2/vector"
 )
 
 // ChunkMatch represents a search result with text and source metadata
 type ChunkMatch struct {
-	Text   string `json:"text"`
-	Source string `json:"source"`
-	Page   int    `json:"page"`
+	ChunkKey   string      `json:"chunk_key"` // chunk::<docID>::v<version>::<n>
+	DocumentID string      `json:"document_id"`
+	ChunkID    string      `json:"chunk_id"`
+	Version    int         `json:"version"` // document version the chunk belongs to
+	Text       string      `json:"text"`
+	Source     string      `json:"source"`
+	Page       int         `json:"page"`
+	Score      float64     `json:"score"` // fused score used for ranking
+	Scores     MatchScores `json:"scores"`
+	// Keys of the chunks joined into Text when neighbors were added, in order
+	NeighborKeys []string `json:"neighbor_keys,omitempty"`
 }
 
+// MatchScores reports how each retrieval method scored and ranked a chunk.
+// Ranks start at 1; 0 means the method did not return the chunk.
+type MatchScores struct {
+	Vector     float64 `json:"vector"`
+	VectorRank int     `json:"vector_rank"`
+	Text       float64 `json:"text"`
+	TextRank   int     `json:"text_rank"`
+	Fused      float64 `json:"fused"`
+	// Set when a reranker ran: its score, the rank before and after reranking
+	Rerank     float64 `json:"rerank,omitempty"`
+	RankBefore int     `json:"rank_before,omitempty"`
+	RankAfter  int     `json:"rank_after,omitempty"`
+	// Reranker score minus retrieval score, both min-max normalized over the candidates
+	RerankDelta float64 `json:"rerank_delta,omitempty"`
+}
+
+// SearchWeights sets how much each retrieval method contributes to the fused ranking
+type SearchWeights struct {
+	Vector float64 `json:"vector"`
+	Text   float64 `json:"text"`
+}
+
+// SearchFilters restrict retrieval to matching chunks. They are sent as pre-filters
+// inside the search request, so the top-k is taken from the filtered set.
+type SearchFilters struct {
+	Category       string     `json:"category"`
+	DocumentIDs    []string   `json:"document_ids"`
+	ContentType    string     `json:"content_type"`
+	UploadedAfter  *time.Time `json:"uploaded_after"`
+	UploadedBefore *time.Time `json:"uploaded_before"`
+}
+
+// SearchParams describes a hybrid retrieval request
+type SearchParams struct {
+	Text    string    // question for full-text (BM25) matching
+	Vector  []float32 // query embedding for vector matching
+	Model   string    // model that produced Vector (default: the configured embedder)
+	Weights *SearchWeights
+	TopK    int // number of chunks to return (0 = SEARCH_TOP_K, default 3)
+	// MinScore is a threshold between 0 and 1 applied to both legs before fusion:
+	// vector hits need at least this cosine similarity, text hits at least this
+	// fraction of the best text hit's BM25 score, as BM25 scores are unbounded
+	MinScore float64
+	Filters  *SearchFilters
+	// Neighbors adds up to this many chunks before and after each hit (0 =
+	// CONTEXT_NEIGHBORS, negative = none), within ContextTokens (0 = CONTEXT_MAX_TOKENS)
+	Neighbors     int
+	ContextTokens int
+}
+
+// maxTopK bounds how many chunks a single request can pull into the prompt
+const maxTopK = 50
+
 // 1. Helper to call Python and get the Vector
 func GetQueryVector(question string) ([]float32, error) {
-	// Payload
-	requestBody, _ := json.Marshal(map[string]string{
-		"text": question,
-	})
+	return Embeddings.Embed(context.Background(), question)
+}
 
-	// Call Python Service
-	resp, err := http.Post("http://localhost:8000/api/v1/embed", "application/json", bytes.NewBuffer(requestBody))
+// SearchResult is the outcome of a retrieval run with its diagnostics
+type SearchResult struct {
+	Matches     []ChunkMatch `json:"matches"`
+	RetrievalMs int64        `json:"retrieval_ms"`
+	Rerank      *RerankStats `json:"rerank,omitempty"`
+}
+
+// 2. The Main Search Function - Returns chunks with source metadata
+func SearchSimilarChunks(params SearchParams) ([]ChunkMatch, error) {
+	result, err := SearchChunks(params)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
+	return result.Matches, nil
+}
+
+// SearchChunks runs a vector query and a full-text match query on the chunk text and
+// merges both rankings with weighted reciprocal rank fusion. When a reranker is
+// configured, it over-fetches candidates and keeps the top-k after rescoring.
+func SearchChunks(params SearchParams) (*SearchResult, error) {
+	started := time.Now()
+
+	limit := params.TopK
+	if limit <= 0 {
+		limit = defaultTopK()
+	}
+	limit = min(limit, maxTopK)
+
+	// Candidates handed to the reranker
+	pool := limit
+	if ActiveReranker != nil {
+		pool = max(rerankCandidates(), limit)
+	}
+	candidates := max(limit*5, pool)
+
+	filter := buildSearchFilter(params.Filters)
 
-	var result struct {
-		Vector []float32 `json:"vector"`
+	weights := DefaultSearchWeights()
+	if params.Weights != nil {
+		weights = *params.Weights
 	}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+
+	// Both legs query the index that holds vectors of the query's model, so text hits
+	// come from the same chunks while a reindex moves queries to the shadow index
+	model := params.Model
+	if model == "" && Embeddings != nil {
+		model = Embeddings.Model()
+	}
+	route, err := SearchRouteFor(model, len(params.Vector))
+	if err != nil {
 		return nil, err
 	}
 
-	return result.Vector, nil
+	var vectorHits, textHits []ChunkMatch
+
+	// A. Vector Search
+	// Matches the vector slot that holds embeddings of the query's model
+	if weights.Vector > 0 && len(params.Vector) > 0 {
+		slot := models.VectorSlotNamed(route.Slot)
+
+		vectorFilter := filter
+		if route.Model != "" {
+			vectorFilter = search.NewConjunctionQuery(filter, search.NewTermQuery(route.Model).Field(slot.ModelField))
+		}
+
+		vQuery := vector.NewQuery(slot.VectorField, params.Vector).
+			NumCandidates(uint32(candidates)).
+			Prefilter(vectorFilter)
+
+		vectorHits, err = runChunkSearch(route.Index, gocb.SearchRequest{
+			VectorSearch: vector.NewSearch([]*vector.Query{vQuery}, nil),
+		}, candidates)
+		if err != nil {
+			return nil, err
+		}
+		vectorHits = dropBelowScore(vectorHits, params.MinScore)
+	}
+
+	// B. Full-Text Search (BM25) catches exact part numbers, acronyms and codes
+	if weights.Text > 0 && strings.TrimSpace(params.Text) != "" {
+		textQuery := search.NewConjunctionQuery(search.NewMatchQuery(params.Text).Field("text"), filter)
+
+		textHits, err = runChunkSearch(route.Index, gocb.SearchRequest{
+			SearchQuery: textQuery,
+		}, candidates)
+		if err != nil {
+			return nil, err
+		}
+		if len(textHits) > 0 {
+			textHits = dropBelowScore(textHits, params.MinScore*textHits[0].Score)
+		}
+	}
+
+	// C. Merge
+	matches := fuseRankings(vectorHits, textHits, weights, rrfK())
+	if len(matches) > pool {
+		matches = matches[:pool]
+	}
+
+	result := &SearchResult{RetrievalMs: time.Since(started).Milliseconds()}
+
+	// D. Rerank
+	if ActiveReranker != nil && len(matches) > 0 {
+		reranked, stats, err := RerankMatches(context.Background(), ActiveReranker, params.Text, matches, limit)
+		if err != nil {
+			// Retrieval order is still usable; don't fail the question over it
+			log.Printf("Warning: Reranking with %s failed, keeping retrieval order: %v", ActiveReranker.Name(), err)
+		} else {
+			matches = reranked
+			result.Rerank = stats
+		}
+	}
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+
+	// E. Neighbor expansion
+	neighbors := params.Neighbors
+	if neighbors == 0 {
+		neighbors = defaultNeighbors()
+	}
+	if neighbors > 0 {
+		budget := params.ContextTokens
+		if budget <= 0 {
+			budget = defaultContextTokens()
+		}
+		matches = ExpandNeighbors(matches, neighbors, budget)
+	}
+	result.Matches = matches
+
+	log.Printf("DEBUG: Total matches with metadata: %d (vector %d, text %d)", len(matches), len(vectorHits), len(textHits))
+	return result, nil
 }
 
-// 2. The Main Search Function - Returns chunks with source metadata
-func SearchSimilarChunks(vectorData []float32) ([]ChunkMatch, error) {
-	// A. Define Vector Query
-	// Matches the "vector" field inside the "chunks" nested array
-	vQuery := vector.NewQuery("chunks.vector", vectorData).
-		NumCandidates(3)
+// defaultTopK reads SEARCH_TOP_K (default 3)
+func defaultTopK() int {
+	if v, err := strconv.Atoi(os.Getenv("SEARCH_TOP_K")); err == nil && v > 0 {
+		return v
+	}
+	return 3
+}
+
+// buildSearchFilter turns the filters into a search query. Chunks of inactive
+// document versions are always excluded.
+func buildSearchFilter(filters *SearchFilters) search.Query {
+	clauses := []search.Query{search.NewBooleanFieldQuery(true).Field("active")}
+	if filters == nil {
+		return clauses[0]
+	}
+
+	if filters.Category != "" {
+		clauses = append(clauses, search.NewTermQuery(filters.Category).Field("category"))
+	}
+	if len(filters.DocumentIDs) > 0 {
+		var ids []search.Query
+		for _, id := range filters.DocumentIDs {
+			ids = append(ids, search.NewTermQuery(id).Field("document_id"))
+		}
+		clauses = append(clauses, search.NewDisjunctionQuery(ids...))
+	}
+	if filters.ContentType != "" {
+		clauses = append(clauses, search.NewTermQuery(filters.ContentType).Field("content_type"))
+	}
+	if filters.UploadedAfter != nil || filters.UploadedBefore != nil {
+		dateRange := search.NewDateRangeQuery().Field("uploaded_at")
+		if filters.UploadedAfter != nil {
+			dateRange = dateRange.Start(filters.UploadedAfter.Format(time.RFC3339), true)
+		}
+		if filters.UploadedBefore != nil {
+			dateRange = dateRange.End(filters.UploadedBefore.Format(time.RFC3339), false)
+		}
+		clauses = append(clauses, dateRange)
+	}
+
+	if len(clauses) == 1 {
+		return clauses[0]
+	}
+	return search.NewConjunctionQuery(clauses...)
+}
+
+// dropBelowScore removes hits scoring under minScore, keeping rank order
+func dropBelowScore(hits []ChunkMatch, minScore float64) []ChunkMatch {
+	if minScore <= 0 {
+		return hits
+	}
+
+	kept := hits[:0]
+	for _, hit := range hits {
+		if hit.Score >= minScore {
+			kept = append(kept, hit)
+		}
+	}
+	return kept
+}
+
+// DefaultSearchWeights reads SEARCH_VECTOR_WEIGHT and SEARCH_TEXT_WEIGHT (both default 1)
+func DefaultSearchWeights() SearchWeights {
+	weights := SearchWeights{Vector: 1, Text: 1}
+	if v, err := strconv.ParseFloat(os.Getenv("SEARCH_VECTOR_WEIGHT"), 64); err == nil && v >= 0 {
+		weights.Vector = v
+	}
+	if v, err := strconv.ParseFloat(os.Getenv("SEARCH_TEXT_WEIGHT"), 64); err == nil && v >= 0 {
+		weights.Text = v
+	}
+	return weights
+}
+
+// rrfK is the reciprocal rank fusion constant (SEARCH_RRF_K, default 60)
+func rrfK() float64 {
+	if v, err := strconv.ParseFloat(os.Getenv("SEARCH_RRF_K"), 64); err == nil && v > 0 {
+		return v
+	}
+	return 60
+}
+
+// fuseRankings merges two ranked hit lists: each chunk scores the sum of
+// weight / (k + rank) over the methods that returned it.
+func fuseRankings(vectorHits, textHits []ChunkMatch, weights SearchWeights, k float64) []ChunkMatch {
+	byKey := make(map[string]*ChunkMatch)
+	var order []string
 
-	// B. Define Vector Search
-	vSearch := vector.NewSearch([]*vector.Query{vQuery}, nil)
+	add := func(hit ChunkMatch) *ChunkMatch {
+		if existing, ok := byKey[hit.ChunkKey]; ok {
+			return existing
+		}
+		match := hit
+		match.Scores = MatchScores{}
+		byKey[hit.ChunkKey] = &match
+		order = append(order, hit.ChunkKey)
+		return &match
+	}
+
+	for i, hit := range vectorHits {
+		match := add(hit)
+		match.Scores.Vector = hit.Score
+		match.Scores.VectorRank = i + 1
+		match.Scores.Fused += weights.Vector / (k + float64(i+1))
+	}
+	for i, hit := range textHits {
+		match := add(hit)
+		match.Scores.Text = hit.Score
+		match.Scores.TextRank = i + 1
+		match.Scores.Fused += weights.Text / (k + float64(i+1))
+	}
 
-	// C. Define Search Request
-	request := gocb.SearchRequest{
-		VectorSearch: vSearch,
+	matches := make([]ChunkMatch, 0, len(order))
+	for _, key := range order {
+		match := *byKey[key]
+		match.Score = match.Scores.Fused
+		matches = append(matches, match)
 	}
 
-	// D. Define Search Options - Include all chunk fields
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].Score > matches[j].Score
+	})
+	return matches
+}
+
+// runChunkSearch executes one search request against a chunk index and
+// returns the hits in rank order with their raw scores
+func runChunkSearch(index string, request gocb.SearchRequest, limit int) ([]ChunkMatch, error) {
+	// Include the stored chunk fields
 	opts := &gocb.SearchOptions{
-		Limit:  3,
-		Fields: []string{"*"}, // Request all fields
+		Limit:  uint32(limit),
+		Fields: []string{"text", "document_id", "chunk_id", "source", "page", "version"},
 	}
 
-	// E. Execute Search (Scoped)
-	bucket := config.Cluster.Bucket("bpt-knowledge-center")
-	scope := bucket.Scope("knowledge-base")
+	// Execute Search (Scoped)
+	bucket := config.Cluster.Bucket(getSearchBucket())
+	scope := bucket.Scope(getSearchScope())
 
-	result, err := scope.Search("knowledge_vector_search", request, opts)
+	result, err := scope.Search(index, request, opts)
 	if err != nil {
 		log.Printf("Search query failed: %v", err)
 		return nil, err
 	}
 
-	// F. Parse Results with metadata
-	var matches []ChunkMatch
+	// Parse Results with metadata
+	var hits []ChunkMatch
 	for result.Next() {
 		row := result.Row()
 
-		// Get the document ID to fetch full document
-		docID := row.ID
-		log.Printf("DEBUG: Found document ID: %s", docID)
+		match := ChunkMatch{ChunkKey: row.ID, Score: row.Score}
 
 		var fields map[string]interface{}
 		if err := row.Fields(&fields); err == nil {
-			log.Printf("DEBUG: Fields returned: %+v", fields)
-
-			match := ChunkMatch{}
-
-			// Try different field patterns
-			// Pattern 1: Direct chunks fields
-			if val, exists := fields["chunks.text"]; exists {
-				match.Text = fmt.Sprintf("%v", val)
-			}
-			if val, exists := fields["chunks.metadata.source"]; exists {
-				match.Source = fmt.Sprintf("%v", val)
-			}
-			if val, exists := fields["chunks.metadata.page"]; exists {
-				if pageNum, ok := val.(float64); ok {
-					match.Page = int(pageNum)
-				}
-			}
-
-			// Pattern 2: Check for nested chunks array
-			if chunks, exists := fields["chunks"]; exists {
-				log.Printf("DEBUG: chunks field exists: %+v", chunks)
-				if chunkArr, ok := chunks.([]interface{}); ok && len(chunkArr) > 0 {
-					if chunk, ok := chunkArr[0].(map[string]interface{}); ok {
-						if text, exists := chunk["text"]; exists {
-							match.Text = fmt.Sprintf("%v", text)
-						}
-						if meta, exists := chunk["metadata"]; exists {
-							if metadata, ok := meta.(map[string]interface{}); ok {
-								if source, exists := metadata["source"]; exists {
-									match.Source = fmt.Sprintf("%v", source)
-								}
-								if page, exists := metadata["page"]; exists {
-									if pageNum, ok := page.(float64); ok {
-										match.Page = int(pageNum)
-									}
-								}
-							}
-						}
-					}
-				}
-			}
+			match = applyChunkFields(match, fields)
+		}
 
-			// If we still don't have source, try to get from document directly
-			if match.Source == "" && docID != "" {
-				match = fetchDocumentMetadata(docID, match)
+		// Index without stored fields: read the chunk document itself
+		if match.Text == "" || match.DocumentID == "" {
+			if chunk, err := repositories.GetChunkByID(row.ID); err == nil {
+				match.DocumentID = chunk.DocumentID
+				match.ChunkID = chunk.ChunkID
+				match.Text = chunk.Text
+				match.Source = chunk.Source
+				match.Page = chunk.Page
+				match.Version = chunk.Version
+			} else {
+				log.Printf("DEBUG: Failed to get chunk %s: %v", row.ID, err)
 			}
+		}
 
-			if match.Text != "" {
-				matches = append(matches, match)
-			}
+		if match.Text != "" {
+			hits = append(hits, match)
 		}
 	}
 
@@ -143,54 +403,44 @@ func SearchSimilarChunks(vectorData []float32) ([]ChunkMatch, error) {
 		return nil, err
 	}
 
-	log.Printf("DEBUG: Total matches with metadata: %+v", matches)
-	return matches, nil
+	return hits, nil
 }
 
-// Helper to fetch document metadata directly from Couchbase
-func fetchDocumentMetadata(docID string, match ChunkMatch) ChunkMatch {
-	bucket := config.Cluster.Bucket("bpt-knowledge-center")
-	scope := bucket.Scope("knowledge-base")
-	collection := scope.Collection("bpt-docs")
+// applyChunkFields copies the stored fields of a search hit onto the match
+func applyChunkFields(match ChunkMatch, fields map[string]interface{}) ChunkMatch {
+	if val, ok := fields["text"].(string); ok {
+		match.Text = val
+	}
+	if val, ok := fields["document_id"].(string); ok {
+		match.DocumentID = val
+	}
+	if val, ok := fields["chunk_id"].(string); ok {
+		match.ChunkID = val
+	}
+	if val, ok := fields["source"].(string); ok {
+		match.Source = val
+	}
+	if val, ok := fields["page"].(float64); ok {
+		match.Page = int(val)
+	}
+	if val, ok := fields["version"].(float64); ok {
+		match.Version = int(val)
+	}
+	return match
+}
 
-	result, err := collection.Get(docID, nil)
-	if err != nil {
-		log.Printf("DEBUG: Failed to get document %s: %v", docID, err)
-		return match
-	}
-
-	var doc map[string]interface{}
-	if err := result.Content(&doc); err != nil {
-		log.Printf("DEBUG: Failed to parse document: %v", err)
-		return match
-	}
-
-	log.Printf("DEBUG: Full document: %+v", doc)
-
-	// Extract filename from document
-	if filename, exists := doc["filename"]; exists {
-		match.Source = fmt.Sprintf("%v", filename)
-	}
-
-	// Try to get from chunks metadata
-	if chunks, exists := doc["chunks"]; exists {
-		if chunkArr, ok := chunks.([]interface{}); ok && len(chunkArr) > 0 {
-			if chunk, ok := chunkArr[0].(map[string]interface{}); ok {
-				if meta, exists := chunk["metadata"]; exists {
-					if metadata, ok := meta.(map[string]interface{}); ok {
-						if source, exists := metadata["source"]; exists && match.Source == "" {
-							match.Source = fmt.Sprintf("%v", source)
-						}
-						if page, exists := metadata["page"]; exists && match.Page == 0 {
-							if pageNum, ok := page.(float64); ok {
-								match.Page = int(pageNum)
-							}
-						}
-					}
-				}
-			}
-		}
+// getSearchBucket returns the bucket holding the search index
+func getSearchBucket() string {
+	if name := os.Getenv("DB_BUCKET"); name != "" {
+		return name
 	}
+	return "bpt-knowledge-center"
+}
 
-	return match
+// getSearchScope returns the scope holding the search index
+func getSearchScope() string {
+	if name := os.Getenv("DB_SCOPE"); name != "" {
+		return name
+	}
+	return "knowledge-base"
 }
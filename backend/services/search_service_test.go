@@ -0,0 +1,102 @@
+package services
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// hits returns search hits for the chunk keys in rank order, scoring from 1 down
+func hits(keys ...string) []ChunkMatch {
+	list := make([]ChunkMatch, len(keys))
+	for i, key := range keys {
+		list[i] = ChunkMatch{ChunkKey: key, Text: key, Score: 1 - float64(i)/10}
+	}
+	return list
+}
+
+func TestFuseRankings(t *testing.T) {
+	tests := []struct {
+		name       string
+		vectorHits []ChunkMatch
+		textHits   []ChunkMatch
+		weights    SearchWeights
+		want       []string
+	}{
+		{"vector only", hits("a", "b"), nil, SearchWeights{Vector: 1, Text: 1}, []string{"a", "b"}},
+		{"text only", nil, hits("a", "b"), SearchWeights{Vector: 1, Text: 1}, []string{"a", "b"}},
+		{"found by both ranks first", hits("a", "b"), hits("c", "b"), SearchWeights{Vector: 1, Text: 1}, []string{"b", "a", "c"}},
+		{"ties keep vector order first", hits("a", "b"), hits("c", "d"), SearchWeights{Vector: 1, Text: 1}, []string{"a", "c", "b", "d"}},
+		{"text weight wins", hits("a", "b"), hits("c", "d"), SearchWeights{Vector: 1, Text: 2}, []string{"c", "d", "a", "b"}},
+		{"zero weight keeps order after the others", hits("a"), hits("b"), SearchWeights{Vector: 0, Text: 1}, []string{"b", "a"}},
+		{"no hits", nil, nil, SearchWeights{Vector: 1, Text: 1}, []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matches := fuseRankings(tt.vectorHits, tt.textHits, tt.weights, 60)
+
+			keys := []string{}
+			for _, match := range matches {
+				keys = append(keys, match.ChunkKey)
+				if match.Score != match.Scores.Fused {
+					t.Errorf("%s score = %v, fused score %v", match.ChunkKey, match.Score, match.Scores.Fused)
+				}
+			}
+			if !reflect.DeepEqual(keys, tt.want) {
+				t.Errorf("order = %v, want %v", keys, tt.want)
+			}
+		})
+	}
+}
+
+func TestFuseRankingsScores(t *testing.T) {
+	matches := fuseRankings(hits("a", "b"), hits("b", "c"), SearchWeights{Vector: 2, Text: 1}, 10)
+
+	want := map[string]MatchScores{
+		"a": {Vector: 1, VectorRank: 1, Fused: 2.0 / 11},
+		"b": {Vector: 0.9, VectorRank: 2, Text: 1, TextRank: 1, Fused: 2.0/12 + 1.0/11},
+		"c": {Text: 0.9, TextRank: 2, Fused: 1.0 / 12},
+	}
+	if len(matches) != len(want) {
+		t.Fatalf("got %d matches, want %d: a chunk found by both searches appears once", len(matches), len(want))
+	}
+	for _, match := range matches {
+		if match.Scores != want[match.ChunkKey] {
+			t.Errorf("%s scores = %+v, want %+v", match.ChunkKey, match.Scores, want[match.ChunkKey])
+		}
+	}
+}
+
+func TestBuildSearchFilter(t *testing.T) {
+	after := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	before := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	const active = `{"bool":true,"field":"active"}`
+
+	tests := []struct {
+		name    string
+		filters *SearchFilters
+		want    string
+	}{
+		{"no filters", nil, active},
+		{"empty filters", &SearchFilters{}, active},
+		{"category", &SearchFilters{Category: "manuals"}, `{"conjuncts":[` + active + `,{"term":"manuals","field":"category"}]}`},
+		{"documents", &SearchFilters{DocumentIDs: []string{"d1", "d2"}}, `{"conjuncts":[` + active + `,{"disjuncts":[{"term":"d1","field":"document_id"},{"term":"d2","field":"document_id"}]}]}`},
+		{"content type", &SearchFilters{ContentType: "application/pdf"}, `{"conjuncts":[` + active + `,{"term":"application/pdf","field":"content_type"}]}`},
+		{"uploaded after", &SearchFilters{UploadedAfter: &after}, `{"conjuncts":[` + active + `,{"start":"2024-01-02T00:00:00Z","inclusive_start":true,"field":"uploaded_at"}]}`},
+		{"uploaded between", &SearchFilters{UploadedAfter: &after, UploadedBefore: &before}, `{"conjuncts":[` + active + `,{"start":"2024-01-02T00:00:00Z","inclusive_start":true,"end":"2024-03-01T00:00:00Z","inclusive_end":false,"field":"uploaded_at"}]}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := json.Marshal(buildSearchFilter(tt.filters))
+			if err != nil {
+				t.Fatalf("marshal: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("filter = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestLocalStoreStatContentType(t *testing.T) {
+	store, err := NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalStore: %v", err)
+	}
+
+	tests := []struct {
+		key     string
+		content string
+		want    string
+	}{
+		{"documents/d1/v1/manual.pdf", "%PDF-1.7\n%%EOF\n", "application/pdf"},
+		{"documents/d1/v1/spec.docx", "PK\x03\x04" + strings.Repeat("x", 100), "application/vnd.openxmlformats-officedocument.wordprocessingml.document"},
+		{"documents/d1/v1/notes.md", "# Notes", "text/markdown"},
+		{"documents/d1/v1/empty.txt", "", "text/plain"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			if err := store.Put(context.Background(), tt.key, strings.NewReader(tt.content), int64(len(tt.content)), ""); err != nil {
+				t.Fatalf("Put: %v", err)
+			}
+
+			info, err := store.Stat(context.Background(), tt.key)
+			if err != nil {
+				t.Fatalf("Stat: %v", err)
+			}
+			if !strings.HasPrefix(info.ContentType, tt.want) {
+				t.Errorf("content type = %q, want %q", info.ContentType, tt.want)
+			}
+		})
+	}
+}
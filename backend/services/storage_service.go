@@ -1,65 +1,242 @@
 package services
 
 import (
+	"bpt-knowledge-center/backend/models"
+	"bpt-knowledge-center/backend/repositories"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
-	"mime/multipart"
+	"mime"
+	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ObjectStore is the file storage used for uploaded documents
+type ObjectStore interface {
+	Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error
+	// Get streams an object; the caller must close the reader
+	Get(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error)
+	Delete(ctx context.Context, key string) error
+	Stat(ctx context.Context, key string) (*ObjectInfo, error)
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+	// Presign returns a time-limited download URL, or ErrPresignUnsupported
+	Presign(ctx context.Context, key string, filename string, expiry time.Duration) (string, error)
+}
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/credentials"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
+// ObjectInfo describes a stored object
+type ObjectInfo struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	ContentType  string    `json:"content_type,omitempty"`
+	LastModified time.Time `json:"last_modified"`
+}
+
+var (
+	// ErrObjectNotFound is returned when no stored object matches
+	ErrObjectNotFound = errors.New("object not found")
+	// ErrPresignUnsupported is returned by stores that cannot issue direct URLs;
+	// downloads are then streamed through the backend
+	ErrPresignUnsupported = errors.New("presigned URLs are not supported by this storage driver")
 )
 
-var S3Client *s3.Client
-var BucketName string
+// Storage is the object store shared by all requests, set by InitStorage
+var Storage ObjectStore
 
-// InitStorage connects to Object Storage using env variables
+// InitStorage connects to Object Storage using env variables. STORAGE_DRIVER selects
+// "s3", "local" (files under STORAGE_LOCAL_DIR, default ./storage) or "memory". Without
+// a driver, S3 is used when STORAGE_ENDPOINT is set and the local directory otherwise.
 func InitStorage() {
-	endpoint := os.Getenv("STORAGE_ENDPOINT")
-	accessKey := os.Getenv("STORAGE_ACCESS_KEY")
-	secretKey := os.Getenv("STORAGE_SECRET_KEY")
-	BucketName = os.Getenv("STORAGE_BUCKET")
-	region := os.Getenv("STORAGE_REGION")
-
-	if endpoint == "" || accessKey == "" || secretKey == "" || BucketName == "" {
-		log.Fatal("Error: Storage configuration missing in .env")
+	driver := strings.ToLower(os.Getenv("STORAGE_DRIVER"))
+	if driver == "" {
+		driver = "s3"
+		if os.Getenv("STORAGE_ENDPOINT") == "" {
+			log.Println("Warning: STORAGE_ENDPOINT not set, storing files on the local filesystem")
+			driver = "local"
+		}
+	}
+
+	store, err := NewObjectStore(driver)
+	if err != nil {
+		log.Fatalf("Error: Failed to initialize %s storage: %v", driver, err)
+	}
+	Storage = store
+
+	fmt.Printf("Connected to Object Storage (%s)\n", driver)
+}
+
+// NewObjectStore builds the store for a driver from its environment variables
+func NewObjectStore(driver string) (ObjectStore, error) {
+	switch driver {
+	case "s3":
+		return NewS3Store(
+			os.Getenv("STORAGE_ENDPOINT"),
+			os.Getenv("STORAGE_ACCESS_KEY"),
+			os.Getenv("STORAGE_SECRET_KEY"),
+			os.Getenv("STORAGE_REGION"),
+			os.Getenv("STORAGE_BUCKET"),
+		)
+	case "local":
+		dir := os.Getenv("STORAGE_LOCAL_DIR")
+		if dir == "" {
+			dir = "./storage"
+		}
+		return NewLocalStore(dir)
+	case "memory":
+		return NewMemoryStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown storage driver %q", driver)
+	}
+}
+
+// ObjectKey builds the content-addressed key of one version of a document's file:
+// documents/<docID>/v<version>/<sha256>/<filename>. Keys never collide across
+// documents or versions, so uploading never overwrites an older version.
+func ObjectKey(documentID string, version int, hash string, filename string) string {
+	return fmt.Sprintf("%s/v%d/%s/%s", ObjectPrefix(documentID), version, hash, SafeFilename(filename))
+}
+
+// ObjectPrefix is the key prefix shared by all versions of a document's file
+func ObjectPrefix(documentID string) string {
+	return "documents/" + strings.ReplaceAll(documentID, "::", "-")
+}
+
+// SafeFilename strips directories and characters that are unsafe in keys and paths
+func SafeFilename(filename string) string {
+	name := filepath.Base(strings.ReplaceAll(filename, "\\", "/"))
+	name = unsafeFilenameChars.ReplaceAllString(name, "_")
+	name = strings.Trim(name, "._ ")
+	if name == "" {
+		name = "file"
+	}
+	return name
+}
+
+var unsafeFilenameChars = regexp.MustCompile(`[^A-Za-z0-9._ -]+`)
+
+// officeContentTypes covers formats that content sniffing reports as plain ZIP or text
+var officeContentTypes = map[string]string{
+	".pdf":  "application/pdf",
+	".docx": "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	".pptx": "application/vnd.openxmlformats-officedocument.presentationml.presentation",
+	".xlsx": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	".doc":  "application/msword",
+	".ppt":  "application/vnd.ms-powerpoint",
+	".xls":  "application/vnd.ms-excel",
+	".md":   "text/markdown; charset=utf-8",
+	".txt":  "text/plain; charset=utf-8",
+	".html": "text/html; charset=utf-8",
+	".htm":  "text/html; charset=utf-8",
+}
+
+// DetectContentType sniffs the MIME type from the file header and refines generic
+// results (ZIP containers, plain text) using the file extension
+func DetectContentType(header []byte, filename string) string {
+	sniffed := http.DetectContentType(header)
+
+	ext := strings.ToLower(filepath.Ext(filename))
+	byExt := officeContentTypes[ext]
+	if byExt == "" {
+		byExt = mime.TypeByExtension(ext)
 	}
 
-	creds := credentials.NewStaticCredentialsProvider(accessKey, secretKey, "")
+	switch {
+	case byExt == "":
+		return sniffed
+	case strings.HasPrefix(sniffed, "application/zip"),
+		strings.HasPrefix(sniffed, "application/octet-stream"),
+		strings.HasPrefix(sniffed, "text/plain") && strings.HasPrefix(byExt, "text/"):
+		return byExt
+	default:
+		return sniffed
+	}
+}
+
+// UploadFile stores the file under the given key. Objects are private; clients get
+// them through DownloadPath or a presigned URL.
+func UploadFile(file io.Reader, key string, size int64, contentType string) error {
+	return Storage.Put(context.TODO(), key, file, size, contentType)
+}
+
+// DownloadPath is the stable backend URL of a document version's file. It issues a
+// fresh presigned URL on every request, unlike a raw bucket URL.
+func DownloadPath(documentID string, version int) string {
+	return fmt.Sprintf("/api/documents/%s/download?version=%d", url.PathEscape(documentID), version)
+}
+
+// DownloadURLExpiry reads DOWNLOAD_URL_EXPIRY (default 15m, at most 7 days as S3 allows)
+func DownloadURLExpiry() time.Duration {
+	expiry := 15 * time.Minute
+	if v, err := time.ParseDuration(os.Getenv("DOWNLOAD_URL_EXPIRY")); err == nil && v > 0 {
+		expiry = v
+	}
+	return min(expiry, 7*24*time.Hour)
+}
 
-	cfg, err := config.LoadDefaultConfig(context.TODO(),
-		config.WithCredentialsProvider(creds),
-		config.WithRegion(region),
-	)
+// PresignDownload returns a short-lived GET URL for the object. The filename is
+// suggested to the browser through Content-Disposition.
+func PresignDownload(key string, filename string) (string, time.Time, error) {
+	expiry := DownloadURLExpiry()
+
+	url, err := Storage.Presign(context.TODO(), key, filename, expiry)
 	if err != nil {
-		log.Fatalf("Error: Failed to load S3 config: %v", err)
+		return "", time.Time{}, err
 	}
+	return url, time.Now().Add(expiry), nil
+}
 
-	S3Client = s3.NewFromConfig(cfg, func(o *s3.Options) {
-		o.UsePathStyle = true
-		o.BaseEndpoint = aws.String(endpoint)
-	})
+// OpenObject streams an object from storage. The caller must close the reader.
+func OpenObject(key string) (io.ReadCloser, *ObjectInfo, error) {
+	return Storage.Get(context.TODO(), key)
+}
 
-	fmt.Println("Connected to Object Storage")
+// DeleteObject removes an object from storage; missing objects are not an error
+func DeleteObject(key string) error {
+	err := Storage.Delete(context.TODO(), key)
+	if errors.Is(err, ErrObjectNotFound) {
+		return nil
+	}
+	return err
 }
 
-func UploadFile(file multipart.File, filename string, size int64) (string, error) {
-	_, err := S3Client.PutObject(context.TODO(), &s3.PutObjectInput{
-		Bucket:        aws.String(BucketName),
-		Key:           aws.String(filename),
-		Body:          file,
-		ContentLength: aws.Int64(size),
-		ContentType:   aws.String("application/pdf"),
-	})
+// FindVersionObjectKey looks up the stored object of a specific document version
+func FindVersionObjectKey(documentID string, version int) (string, error) {
+	prefix := fmt.Sprintf("%s/v%d/", ObjectPrefix(documentID), version)
 
+	objects, err := Storage.List(context.TODO(), prefix)
 	if err != nil {
-		return "", fmt.Errorf("failed to upload to S3: %v", err)
+		return "", err
+	}
+	if len(objects) == 0 {
+		return "", ErrObjectNotFound
 	}
 
-	// Construct the URL using the endpoint from env
-	endpoint := os.Getenv("STORAGE_ENDPOINT")
-	return fmt.Sprintf("%s/%s/%s", endpoint, BucketName, filename), nil
+	return objects[0].Key, nil
+}
+
+// ContentDisposition builds an attachment header that survives non-ASCII names
+func ContentDisposition(filename string) string {
+	return fmt.Sprintf("attachment; filename=%q; filename*=UTF-8''%s", SafeFilename(filename), url.PathEscape(filename))
+}
+
+// ResolveObjectKey returns the storage key of a version of a document. Older versions
+// come from their version record, or from a listing if they predate those records.
+// Documents uploaded before versioned keys were stored under their filename.
+func ResolveObjectKey(doc *models.Document, version int) (string, error) {
+	if version == doc.Version {
+		if doc.ObjectKey != "" {
+			return doc.ObjectKey, nil
+		}
+		return doc.Filename, nil
+	}
+	if record, err := repositories.GetDocumentVersion(doc.ID, version); err == nil && record.ObjectKey != "" {
+		return record.ObjectKey, nil
+	}
+	return FindVersionObjectKey(doc.ID, version)
 }
@@ -0,0 +1,53 @@
+package services
+
+import "testing"
+
+func TestObjectKey(t *testing.T) {
+	tests := []struct {
+		name     string
+		id       string
+		version  int
+		filename string
+		want     string
+	}{
+		{"plain", "doc::abc", 1, "manual.pdf", "documents/doc-abc/v1/h/manual.pdf"},
+		{"directories stripped", "doc::abc", 2, "../../etc/passwd.txt", "documents/doc-abc/v2/h/passwd.txt"},
+		{"windows path", "doc::abc", 3, `C:\Users\me\spec.docx`, "documents/doc-abc/v3/h/spec.docx"},
+		{"unsafe characters replaced", "doc::abc", 1, "My Report (v2).pdf", "documents/doc-abc/v1/h/My Report _v2_.pdf"},
+		{"nothing left of the name", "doc::abc", 1, "...", "documents/doc-abc/v1/h/file"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ObjectKey(tt.id, tt.version, "h", tt.filename); got != tt.want {
+				t.Errorf("ObjectKey = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDetectContentType(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   string
+		filename string
+		want     string
+	}{
+		{"pdf", "%PDF-1.7\n", "manual.pdf", "application/pdf"},
+		{"docx from zip", "PK\x03\x04rest", "spec.docx", "application/vnd.openxmlformats-officedocument.wordprocessingml.document"},
+		{"xlsx from zip", "PK\x03\x04rest", "SHEET.XLSX", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"},
+		{"legacy office from binary", "\xd0\xcf\x11\xe0\x00\x01", "old.xls", "application/vnd.ms-excel"},
+		{"markdown from text", "# Title\n\ntext", "notes.md", "text/markdown; charset=utf-8"},
+		{"html content wins over txt name", "<!DOCTYPE html><html></html>", "page.txt", "text/html; charset=utf-8"},
+		{"pdf content wins over docx name", "%PDF-1.7\n", "report.docx", "application/pdf"},
+		{"unknown extension", "plain words", "data.unknownext", "text/plain; charset=utf-8"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DetectContentType([]byte(tt.header), tt.filename); got != tt.want {
+				t.Errorf("DetectContentType = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package services
+
+import (
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// EstimateTokens approximates how many tokens a subword tokenizer splits text into:
+// one per short word or punctuation run, and one per four characters of longer ones
+func EstimateTokens(text string) int {
+	tokens := 0
+	for _, word := range strings.Fields(text) {
+		tokens += wordTokens(word)
+	}
+	return tokens
+}
+
+func wordTokens(word string) int {
+	return max(1, (utf8.RuneCountInString(word)+3)/4)
+}
+
+// modelLimits describes the tokenizer and context window of a family of LLMs
+type modelLimits struct {
+	prefix        string
+	contextWindow int
+	tokenRatio    float64 // tokens per EstimateTokens unit
+}
+
+// knownModelLimits is matched by prefix in order, so specific names come first.
+// EstimateTokens counts about four characters per token, which is what the large
+// BPE vocabularies of Gemini and GPT average on English text, so their ratio is 1.0.
+// Llama 3 and Qwen use similar vocabularies but split code, numbers and non-English
+// text more finely, hence 1.1. Llama 2 and Mistral have 32k SentencePiece
+// vocabularies that produce about a fifth more tokens, hence 1.2.
+var knownModelLimits = []modelLimits{
+	// Gemini and OpenAI: large BPE vocabularies
+	{"gemini-1.5-pro", 2097152, 1.0},
+	{"gemini", 1048576, 1.0},
+	{"gpt-4.1", 1047576, 1.0},
+	{"gpt-4o", 128000, 1.0},
+	{"gpt-4-turbo", 128000, 1.0},
+	{"gpt-4", 8192, 1.0},
+	{"gpt-3.5", 16385, 1.0},
+	{"o1", 200000, 1.0},
+	{"o3", 200000, 1.0},
+	{"o4", 200000, 1.0},
+	// Llama 3 and Qwen: 128k-150k vocabularies
+	{"llama3.1", 131072, 1.1},
+	{"llama3.2", 131072, 1.1},
+	{"llama3.3", 131072, 1.1},
+	{"llama3", 8192, 1.1},
+	{"qwen", 32768, 1.1},
+	// Llama 2 and Mistral: 32k SentencePiece vocabularies
+	{"llama2", 4096, 1.2},
+	{"mistral", 32768, 1.2},
+	{"mixtral", 32768, 1.2},
+	{"fake", 8192, 1.0},
+}
+
+// unknownModelLimits is used for models not listed above, erring on the small side
+var unknownModelLimits = modelLimits{contextWindow: 8192, tokenRatio: 1.2}
+
+func limitsFor(model string) modelLimits {
+	name := strings.ToLower(model)
+	// "models/gemini-pro" or "meta-llama/llama3" name the model after the slash
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	name = strings.Replace(name, "llama-", "llama", 1)
+	for _, limits := range knownModelLimits {
+		if strings.HasPrefix(name, limits.prefix) {
+			return limits
+		}
+	}
+	return unknownModelLimits
+}
+
+// ContextWindow returns the number of tokens the model accepts for prompt and answer
+// together. LLM_CONTEXT_WINDOW overrides it for models not known here.
+func ContextWindow(model string) int {
+	if v, err := strconv.Atoi(os.Getenv("LLM_CONTEXT_WINDOW")); err == nil && v > 0 {
+		return v
+	}
+	return limitsFor(model).contextWindow
+}
+
+// CountTokens estimates how many tokens the model's tokenizer splits text into
+func CountTokens(model string, text string) int {
+	return int(math.Ceil(float64(EstimateTokens(text)) * limitsFor(model).tokenRatio))
+}
+
+// TruncateTokens cuts text between words so it counts at most limit tokens for the model
+func TruncateTokens(model string, text string, limit int) string {
+	ratio := limitsFor(model).tokenRatio
+	words := strings.Fields(text)
+
+	tokens, end := 0.0, 0
+	for end < len(words) {
+		t := float64(wordTokens(words[end])) * ratio
+		if math.Ceil(tokens+t) > float64(limit) {
+			break
+		}
+		tokens += t
+		end++
+	}
+	return strings.Join(words[:end], " ")
+}
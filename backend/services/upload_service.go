@@ -0,0 +1,80 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// TempUpload is an uploaded file spooled to a uniquely named temp file
+type TempUpload struct {
+	Path   string
+	Hash   string // hex SHA-256 of the content
+	Size   int64
+	Header []byte // first bytes of the content, for MIME sniffing
+}
+
+// SpoolUpload copies an upload into a new file under TempDir while hashing it, so the
+// request body is read only once. The name is random and only keeps the sanitized
+// extension, so concurrent uploads of the same filename never share a path.
+func SpoolUpload(r io.Reader, filename string) (*TempUpload, error) {
+	dir := TempDir()
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create temp directory: %v", err)
+	}
+
+	ext := strings.ToLower(filepath.Ext(SafeFilename(filename)))
+	file, err := os.CreateTemp(dir, "upload-*"+ext)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	header := &headerBuffer{limit: 512}
+	size, err := io.Copy(file, io.TeeReader(r, io.MultiWriter(hasher, header)))
+	if err == nil {
+		err = file.Sync()
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return nil, fmt.Errorf("failed to write temp file: %w", err)
+	}
+
+	return &TempUpload{
+		Path:   file.Name(),
+		Hash:   hex.EncodeToString(hasher.Sum(nil)),
+		Size:   size,
+		Header: header.data,
+	}, nil
+}
+
+// Open reopens the spooled file for reading
+func (u *TempUpload) Open() (*os.File, error) {
+	return os.Open(u.Path)
+}
+
+// Remove deletes the spooled file
+func (u *TempUpload) Remove() {
+	if err := os.Remove(u.Path); err != nil && !os.IsNotExist(err) {
+		log.Printf("Warning: Failed to remove %s: %v", u.Path, err)
+	}
+}
+
+// headerBuffer keeps the first limit bytes written to it
+type headerBuffer struct {
+	data  []byte
+	limit int
+}
+
+func (b *headerBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - len(b.data); room > 0 {
+		b.data = append(b.data, p[:min(room, len(p))]...)
+	}
+	return len(p), nil
+}
@@ -0,0 +1,134 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Machine-readable upload error codes
+const (
+	UploadTooLarge        = "file_too_large"
+	UploadEmpty           = "file_empty"
+	UploadUnsupportedType = "unsupported_type"
+	UploadTypeMismatch    = "content_type_mismatch"
+	UploadEncrypted       = "file_encrypted"
+	UploadInfected        = "file_infected"
+	UploadScanFailed      = "scan_failed"
+)
+
+// UploadError is an upload rejected by validation or scanning
+type UploadError struct {
+	Code    string
+	Message string
+}
+
+func (e *UploadError) Error() string {
+	return e.Message
+}
+
+// allowedUploadTypes maps accepted extensions to the sniffed content types their
+// content may have. Office formats sniff as ZIP containers.
+var allowedUploadTypes = map[string][]string{
+	".pdf":  {"application/pdf"},
+	".docx": {"application/zip"},
+	".pptx": {"application/zip"},
+	".xlsx": {"application/zip"},
+	".txt":  {"text/plain"},
+	".md":   {"text/plain"},
+	".html": {"text/html", "text/plain"},
+	".htm":  {"text/html", "text/plain"},
+}
+
+// oleHeader starts compound files, which is how password-protected Office files are stored
+var oleHeader = []byte{0xD0, 0xCF, 0x11, 0xE0, 0xA1, 0xB1, 0x1A, 0xE1}
+
+// MaxUploadSize reads MAX_UPLOAD_SIZE in bytes (default 50 MiB)
+func MaxUploadSize() int64 {
+	if v, err := strconv.ParseInt(os.Getenv("MAX_UPLOAD_SIZE"), 10, 64); err == nil && v > 0 {
+		return v
+	}
+	return 50 << 20
+}
+
+// ValidateUpload checks a spooled upload against the size limit and the allow-list and
+// rejects empty and encrypted files. It returns the detected content type.
+func ValidateUpload(upload *TempUpload, filename string) (string, error) {
+	if upload.Size > MaxUploadSize() {
+		return "", &UploadError{UploadTooLarge, fmt.Sprintf("File exceeds the maximum size of %d bytes", MaxUploadSize())}
+	}
+	if upload.Size == 0 || (upload.Size <= int64(len(upload.Header)) && len(bytes.TrimSpace(upload.Header)) == 0) {
+		return "", &UploadError{UploadEmpty, "File is empty"}
+	}
+
+	ext := strings.ToLower(filepath.Ext(filename))
+	allowed, ok := allowedUploadTypes[ext]
+	if !ok {
+		return "", &UploadError{UploadUnsupportedType, "Unsupported file type " + ext + " (allowed: PDF, DOCX, PPTX, XLSX, TXT, MD, HTML)"}
+	}
+
+	if bytes.HasPrefix(upload.Header, oleHeader) && ext != ".txt" && ext != ".md" {
+		return "", &UploadError{UploadEncrypted, "Password-protected files are not supported"}
+	}
+
+	sniffed := http.DetectContentType(upload.Header)
+	if !hasAnyPrefix(sniffed, allowed) {
+		return "", &UploadError{UploadTypeMismatch, fmt.Sprintf("File content (%s) does not match its %s extension", strings.Split(sniffed, ";")[0], ext)}
+	}
+
+	if ext == ".pdf" {
+		encrypted, err := fileContains(upload.Path, []byte("/Encrypt"))
+		if err != nil {
+			return "", err
+		}
+		if encrypted {
+			return "", &UploadError{UploadEncrypted, "Encrypted PDFs are not supported"}
+		}
+	}
+
+	return DetectContentType(upload.Header, filename), nil
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// fileContains streams a file looking for a byte sequence
+func fileContains(path string, needle []byte) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReaderSize(file, 64<<10)
+	buf := make([]byte, 64<<10)
+	var carry []byte
+	for {
+		n, err := reader.Read(buf)
+		if n > 0 {
+			window := append(carry, buf[:n]...)
+			if bytes.Contains(window, needle) {
+				return true, nil
+			}
+			// Keep the tail in case the needle spans two reads
+			carry = append([]byte(nil), window[max(0, len(window)-len(needle)+1):]...)
+		}
+		if err == io.EOF {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+// spool writes content through SpoolUpload into a temp directory of the test
+func spool(t *testing.T, content string, filename string) *TempUpload {
+	t.Helper()
+	t.Setenv("TEMP_DIR", t.TempDir())
+	upload, err := SpoolUpload(strings.NewReader(content), filename)
+	if err != nil {
+		t.Fatalf("SpoolUpload: %v", err)
+	}
+	return upload
+}
+
+func uploadErrorCode(err error) string {
+	var uploadErr *UploadError
+	if errors.As(err, &uploadErr) {
+		return uploadErr.Code
+	}
+	return ""
+}
+
+func TestValidateUpload(t *testing.T) {
+	const pdf = "%PDF-1.7\n1 0 obj\n<< /Type /Catalog >>\nendobj\n%%EOF\n"
+	ole := string(oleHeader) + strings.Repeat("\x00", 504)
+
+	tests := []struct {
+		name     string
+		filename string
+		content  string
+		wantType string // expected content type prefix when valid
+		wantCode string // expected error code when rejected
+	}{
+		{name: "pdf", filename: "manual.pdf", content: pdf, wantType: "application/pdf"},
+		{name: "docx", filename: "spec.DOCX", content: "PK\x03\x04" + strings.Repeat("x", 100), wantType: "application/vnd.openxmlformats-officedocument.wordprocessingml.document"},
+		{name: "markdown", filename: "notes.md", content: "# Notes\n\nSome text.", wantType: "text/"},
+		{name: "empty", filename: "empty.txt", content: "", wantCode: UploadEmpty},
+		{name: "whitespace only", filename: "blank.txt", content: " \n\t\n", wantCode: UploadEmpty},
+		{name: "unsupported extension", filename: "setup.exe", content: "MZ\x90\x00", wantCode: UploadUnsupportedType},
+		{name: "no extension", filename: "README", content: "text", wantCode: UploadUnsupportedType},
+		{name: "encrypted pdf", filename: "secret.pdf", content: "%PDF-1.7\n<< /Encrypt 5 0 R >>\n%%EOF", wantCode: UploadEncrypted},
+		{name: "encrypt marker across read boundary", filename: "secret.pdf", content: "%PDF-1.7\n" + strings.Repeat("a", 64<<10-12) + "/Encrypt 5 0 R", wantCode: UploadEncrypted},
+		{name: "password-protected docx", filename: "locked.docx", content: ole, wantCode: UploadEncrypted},
+		{name: "password-protected xlsx", filename: "locked.xlsx", content: ole, wantCode: UploadEncrypted},
+		{name: "pdf named docx", filename: "report.docx", content: pdf, wantCode: UploadTypeMismatch},
+		{name: "html named pdf", filename: "page.pdf", content: "<!DOCTYPE html><html><body>hi</body></html>", wantCode: UploadTypeMismatch},
+		{name: "text named pptx", filename: "slides.pptx", content: "plain text", wantCode: UploadTypeMismatch},
+		{name: "executable named txt", filename: "notes.txt", content: "MZ\x90\x00\x03\x00\x00\x00\x04\x00\x00\x00\xff\xff", wantCode: UploadTypeMismatch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upload := spool(t, tt.content, tt.filename)
+
+			contentType, err := ValidateUpload(upload, tt.filename)
+
+			if tt.wantCode != "" {
+				if code := uploadErrorCode(err); code != tt.wantCode {
+					t.Fatalf("err = %v (code %q), want code %q", err, code, tt.wantCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ValidateUpload: %v", err)
+			}
+			if !strings.HasPrefix(contentType, tt.wantType) {
+				t.Errorf("content type = %q, want %q", contentType, tt.wantType)
+			}
+		})
+	}
+}
+
+func TestValidateUploadSizeLimit(t *testing.T) {
+	t.Setenv("MAX_UPLOAD_SIZE", "16")
+	upload := spool(t, "%PDF-1.7\n"+strings.Repeat("a", 16), "big.pdf")
+
+	if _, err := ValidateUpload(upload, "big.pdf"); uploadErrorCode(err) != UploadTooLarge {
+		t.Errorf("err = %v, want code %q", err, UploadTooLarge)
+	}
+}
+
+func TestScanUploadWithFakeScanner(t *testing.T) {
+	previous := ActiveScanner
+	t.Cleanup(func() { ActiveScanner = previous })
+	ActiveScanner = &FakeScanner{Signatures: []string{eicarSignature, "BAD-MARKER"}}
+
+	tests := []struct {
+		name     string
+		content  string
+		remove   bool // delete the spooled file so the scanner fails
+		wantCode string
+	}{
+		{name: "clean", content: "nothing to see here"},
+		{name: "eicar", content: "prefix " + eicarSignature + " suffix", wantCode: UploadInfected},
+		{name: "custom signature", content: "contains BAD-MARKER", wantCode: UploadInfected},
+		{name: "scanner error", content: "clean", remove: true, wantCode: UploadScanFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upload := spool(t, tt.content, "file.txt")
+			if tt.remove {
+				os.Remove(upload.Path)
+			}
+
+			err := ScanUpload(context.Background(), upload)
+			if code := uploadErrorCode(err); code != tt.wantCode || (tt.wantCode == "" && err != nil) {
+				t.Errorf("err = %v (code %q), want code %q", err, code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestSpoolUploadHashesAndKeepsHeader(t *testing.T) {
+	content := strings.Repeat("0123456789", 100)
+	upload := spool(t, content, "../../etc/passwd.txt")
+
+	if upload.Size != int64(len(content)) {
+		t.Errorf("size = %d, want %d", upload.Size, len(content))
+	}
+	if string(upload.Header) != content[:512] {
+		t.Errorf("header holds %d bytes, want the first 512", len(upload.Header))
+	}
+	sum := sha256.Sum256([]byte(content))
+	if want := hex.EncodeToString(sum[:]); upload.Hash != want {
+		t.Errorf("hash = %s, want %s", upload.Hash, want)
+	}
+	if !strings.HasPrefix(upload.Path, TempDir()) || !strings.HasSuffix(upload.Path, ".txt") {
+		t.Errorf("spooled to %s, want a .txt file under %s", upload.Path, TempDir())
+	}
+}
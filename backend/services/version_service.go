@@ -0,0 +1,194 @@
+package services
+
+import (
+	"bpt-knowledge-center/backend/models"
+	"bpt-knowledge-center/backend/repositories"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+var (
+	ErrVersionNotFound      = errors.New("version not found")
+	ErrVersionIsCurrent     = errors.New("version is already the current one")
+	ErrVersionNotRestorable = errors.New("the chunks of this version are no longer stored")
+)
+
+// RecordVersion stores the version record of a document that was just saved
+func RecordVersion(doc *models.Document, uploadedBy string, changeNote string) error {
+	record := models.NewDocumentVersion(doc, len(doc.Chunks), uploadedBy, changeNote)
+	return repositories.CreateDocumentVersion(&record)
+}
+
+// NextVersion allocates the version number of a new version of doc. A document saved
+// before version records existed gets the record of its current version first, as
+// that version would otherwise drop out of the history once it is superseded.
+func NextVersion(doc *models.Document) (int, error) {
+	if _, err := repositories.GetDocumentVersion(doc.ID, doc.Version); errors.Is(err, gocb.ErrDocumentNotFound) {
+		chunkCount, err := repositories.CountDocumentChunks(doc.ID, doc.Version)
+		if err != nil {
+			return 0, err
+		}
+		record := models.NewDocumentVersion(doc, chunkCount, "", "")
+		if record.ObjectKey == "" {
+			// Uploads from before versioned keys are stored under their filename
+			record.ObjectKey = doc.Filename
+		}
+		if err := repositories.CreateDocumentVersion(&record); err != nil {
+			return 0, err
+		}
+	} else if err != nil {
+		return 0, err
+	}
+
+	return repositories.NextDocumentVersion(doc.ID, doc.Version)
+}
+
+// ListVersions returns the version history of a document, newest first, with the
+// current version marked
+func ListVersions(documentID string) ([]models.DocumentVersionView, error) {
+	doc, err := repositories.GetDocumentByID(documentID)
+	if err != nil {
+		return nil, err
+	}
+
+	versions, err := repositories.GetDocumentVersions(documentID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Documents saved before version records existed only know their current version
+	if len(versions) == 0 || versions[0].Version < doc.Version {
+		chunkCount := len(doc.Chunks)
+		if chunkCount == 0 {
+			if chunkCount, err = repositories.CountDocumentChunks(doc.ID, doc.Version); err != nil {
+				return nil, err
+			}
+		}
+		current := models.NewDocumentVersion(doc, chunkCount, "", "")
+		versions = append([]models.DocumentVersion{current}, versions...)
+	}
+
+	views := make([]models.DocumentVersionView, len(versions))
+	for i, version := range versions {
+		views[i] = models.DocumentVersionView{DocumentVersion: version, Current: version.Version == doc.Version}
+	}
+
+	return views, nil
+}
+
+// RestoreVersion makes an older version current again. It is saved as a new version
+// whose chunks are copied from the old one, so nothing is parsed or embedded again
+// and the history stays append-only.
+func RestoreVersion(documentID string, version int, restoredBy string, changeNote string) (*models.DocumentVersionView, error) {
+	doc, err := repositories.GetDocumentByID(documentID)
+	if err != nil {
+		return nil, err
+	}
+	if version == doc.Version {
+		return nil, ErrVersionIsCurrent
+	}
+
+	record, err := repositories.GetDocumentVersion(documentID, version)
+	if errors.Is(err, gocb.ErrDocumentNotFound) {
+		return nil, ErrVersionNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	chunks, err := repositories.GetChunksByVersion(documentID, version)
+	if err != nil {
+		return nil, err
+	}
+	if len(chunks) == 0 {
+		return nil, ErrVersionNotRestorable
+	}
+
+	next, err := NextVersion(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	restored := *doc
+	restored.Version = next
+	restored.Filename = record.Filename
+	restored.ObjectKey = record.ObjectKey
+	restored.FileHash = record.FileHash
+	restored.FileSize = record.FileSize
+	restored.ContentType = record.ContentType
+	restored.ElementCount = record.ElementCount
+	restored.FileURL = DownloadPath(documentID, restored.Version)
+	restored.UpdatedAt = time.Now()
+	restored.Chunks = make([]models.DocumentChunk, len(chunks))
+	for i, chunk := range chunks {
+		restored.Chunks[i] = models.DocumentChunk{
+			ChunkID:        chunk.ChunkID,
+			Text:           chunk.Text,
+			Type:           chunk.ElementType,
+			Metadata:       chunk.Metadata,
+			Vector:         chunk.Vector,
+			Fingerprint:    chunk.Fingerprint,
+			EmbeddingModel: chunk.EmbeddingModel,
+		}
+	}
+	if err := reembedStaleChunks(restored.Chunks); err != nil {
+		return nil, err
+	}
+
+	if err := repositories.SaveDocument(&restored); err != nil {
+		return nil, err
+	}
+	if err := SyncShadowVectors(restored.ID, restored.Version); err != nil {
+		log.Printf("Warning: Failed to mirror vectors of %s v%d to the shadow index: %v", restored.ID, restored.Version, err)
+	}
+
+	if changeNote == "" {
+		changeNote = fmt.Sprintf("Restored from version %d", version)
+	}
+	newRecord := models.NewDocumentVersion(&restored, len(chunks), restoredBy, changeNote)
+	newRecord.RestoredFrom = version
+	if err := repositories.CreateDocumentVersion(&newRecord); err != nil {
+		return nil, err
+	}
+
+	return &models.DocumentVersionView{DocumentVersion: newRecord, Current: true}, nil
+}
+
+// reembedStaleChunks replaces vectors of another model than the configured one, as
+// chunks of old versions keep the model they were saved with
+func reembedStaleChunks(chunks []models.DocumentChunk) error {
+	var stale []int
+	for i, chunk := range chunks {
+		if chunk.Text == "" || chunk.Type == titleElementType {
+			continue
+		}
+		if len(chunk.Vector) == 0 || (chunk.EmbeddingModel != "" && chunk.EmbeddingModel != Embeddings.Model()) {
+			stale = append(stale, i)
+		}
+	}
+
+	batchSize := embeddingBatchSize()
+	for start := 0; start < len(stale); start += batchSize {
+		end := min(start+batchSize, len(stale))
+
+		texts := make([]string, 0, end-start)
+		for _, idx := range stale[start:end] {
+			texts = append(texts, chunks[idx].Text)
+		}
+
+		vectors, err := Embeddings.EmbedBatch(context.Background(), texts)
+		if err != nil {
+			return fmt.Errorf("embedding failed: %w", err)
+		}
+		for j, idx := range stale[start:end] {
+			chunks[idx].Vector = vectors[j]
+			chunks[idx].EmbeddingModel = Embeddings.Model()
+		}
+	}
+
+	return nil
+}
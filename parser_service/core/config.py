@@ -6,6 +6,7 @@ class Settings(BaseSettings):
     API_V1_STR: str = "/api/v1"
     PROJECT_NAME: str = "Parser Service"
     EMBEDDING_MODEL: str = "all-MiniLM-L6-v2"
+    RERANK_MODEL: str = "cross-encoder/ms-marco-MiniLM-L-6-v2"
     HOST: str = "0.0.0.0"
     PORT: int = 8000
     HF_TOKEN: str = ""
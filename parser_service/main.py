@@ -2,12 +2,12 @@ import os
 import shutil
 import tempfile
 import asyncio
-from fastapi import FastAPI, UploadFile, File, HTTPException
+from fastapi import FastAPI, UploadFile, File, Form, HTTPException
 from concurrent.futures import ThreadPoolExecutor
 
 from core.config import settings
 from core.logging_config import setup_logging
-from models.schemas import ParseResponse, EmbedRequest, EmbedResponse
+from models.schemas import ParseResponse, EmbedRequest, EmbedResponse, EmbedBatchRequest, EmbedBatchResponse, RerankRequest, RerankResponse
 from services.document_processor import document_processor
 
 # Setup logging
@@ -20,7 +20,9 @@ thread_pool = ThreadPoolExecutor(max_workers=os.cpu_count() or 1)
 
 
 @app.post(f"{settings.API_V1_STR}/parse", response_model=ParseResponse)
-async def parse_document(file: UploadFile = File(...)):
+async def parse_document(file: UploadFile = File(...), embed: bool = Form(True)):
+    # embed=false returns elements without vectors; the backend then embeds only
+    # the text it cannot reuse from a previous version
     filename = file.filename
     logger.info(f"Received file upload: {filename}")
 
@@ -40,14 +42,16 @@ async def parse_document(file: UploadFile = File(...)):
             thread_pool,
             document_processor.process_pdf,
             temp_path,
-            filename
+            filename,
+            embed
         )
 
         return ParseResponse(
             filename=filename,
             content_type="application/pdf",
             element_count=len(extracted_data),
-            data=extracted_data
+            data=extracted_data,
+            embedding_model=settings.EMBEDDING_MODEL if embed else ""
         )
 
     except Exception as e:
@@ -77,13 +81,50 @@ async def embed_text(req: EmbedRequest):
 
         return EmbedResponse(
             text=req.text,
-            vector=embedding
+            vector=embedding,
+            model=settings.EMBEDDING_MODEL
         )
     except Exception as e:
         logger.error(f"Error embedding text: {e}", exc_info=True)
         raise HTTPException(status_code=500, detail=str(e))
 
 
+@app.post(f"{settings.API_V1_STR}/embed/batch", response_model=EmbedBatchResponse)
+async def embed_batch(req: EmbedBatchRequest):
+    if any(not text.strip() for text in req.texts):
+        raise HTTPException(status_code=422, detail="texts must not be empty")
+
+    try:
+        loop = asyncio.get_event_loop()
+        vectors = await loop.run_in_executor(
+            thread_pool,
+            document_processor.embed_batch,
+            req.texts
+        )
+
+        return EmbedBatchResponse(vectors=vectors, model=settings.EMBEDDING_MODEL)
+    except Exception as e:
+        logger.error(f"Error embedding batch: {e}", exc_info=True)
+        raise HTTPException(status_code=500, detail=str(e))
+
+
+@app.post(f"{settings.API_V1_STR}/rerank", response_model=RerankResponse)
+async def rerank(req: RerankRequest):
+    try:
+        loop = asyncio.get_event_loop()
+        scores = await loop.run_in_executor(
+            thread_pool,
+            document_processor.rerank,
+            req.query,
+            req.texts
+        )
+
+        return RerankResponse(scores=scores)
+    except Exception as e:
+        logger.error(f"Error reranking: {e}", exc_info=True)
+        raise HTTPException(status_code=500, detail=str(e))
+
+
 if __name__ == "__main__":
     import uvicorn
     uvicorn.run("main:app", host=settings.HOST,
@@ -7,7 +7,7 @@ class ContentItem(BaseModel):
     text: str
     type: str
     metadata: Dict[str, Any]
-    vector: List[float]
+    vector: List[float] = []
 
 
 class ParseResponse(BaseModel):
@@ -15,6 +15,7 @@ class ParseResponse(BaseModel):
     content_type: str
     element_count: int
     data: List[ContentItem]
+    embedding_model: str = ""  # empty when the elements were not embedded
 
 
 class EmbedRequest(BaseModel):
@@ -24,3 +25,22 @@ class EmbedRequest(BaseModel):
 class EmbedResponse(BaseModel):
     text: str
     vector: List[float]
+    model: str
+
+
+class EmbedBatchRequest(BaseModel):
+    texts: List[str]
+
+
+class EmbedBatchResponse(BaseModel):
+    vectors: List[List[float]]
+    model: str
+
+
+class RerankRequest(BaseModel):
+    query: str
+    texts: List[str]
+
+
+class RerankResponse(BaseModel):
+    scores: List[float]
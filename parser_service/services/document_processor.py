@@ -1,7 +1,7 @@
 import fitz
 import logging
 from typing import List, Dict, Any
-from sentence_transformers import SentenceTransformer
+from sentence_transformers import SentenceTransformer, CrossEncoder
 import transformers
 from models.schemas import ContentItem
 from core.config import settings
@@ -25,11 +25,26 @@ class DocumentProcessor:
         )
         logger.info("Model loaded")
 
+        # The cross-encoder is only loaded once reranking is first requested
+        self.reranker = None
+
     def embed_text(self, text: str) -> List[float]:
         # Simple wrapper around model.encode
         return self.model.encode(text).tolist()
 
-    def process_pdf(self, file_path: str, filename: str) -> List[ContentItem]:
+    def embed_batch(self, texts: List[str]) -> List[List[float]]:
+        # Encoding in one call lets the model batch internally
+        return self.model.encode(texts).tolist()
+
+    def rerank(self, query: str, texts: List[str]) -> List[float]:
+        if self.reranker is None:
+            logger.info(f"Loading Rerank Model: {settings.RERANK_MODEL}")
+            self.reranker = CrossEncoder(settings.RERANK_MODEL)
+        if not texts:
+            return []
+        return self.reranker.predict([(query, text) for text in texts]).tolist()
+
+    def process_pdf(self, file_path: str, filename: str, embed: bool = True) -> List[ContentItem]:
         logger.info(f"Processing file: {filename}")
         doc = fitz.open(file_path)
         extracted_data = []
@@ -47,7 +62,7 @@ class DocumentProcessor:
                         continue
 
                     # Embedding generation is blocking, so this function is blocking
-                    embedding = self.embed_text(clean_text)
+                    embedding = self.embed_text(clean_text) if embed else []
 
                     extracted_data.append(ContentItem(
                         element_id=f"p{page_num}_b{i}",